@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
 	"github.com/atekoa/dvc-http-remote/pkg/handler"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"github.com/atekoa/dvc-http-remote/pkg/s3api"
 	"github.com/atekoa/dvc-http-remote/pkg/storage"
+	"github.com/atekoa/dvc-http-remote/pkg/trash"
 
 	_ "net/http/pprof"
 )
@@ -34,20 +41,71 @@ func main() {
 	defer cleanup()
 
 	storage := storage.NewStorageSiteLoader(dir)
+	if configFile, ok := os.LookupEnv("REMOTES_CONFIG"); ok {
+		if err := storage.LoadFile(configFile); err != nil {
+			log.
+				WithField("REMOTES_CONFIG", configFile).
+				WithError(err).
+				Fatal("Cannot load remotes configuration")
+		}
+	}
+	if dvcConfig, ok := os.LookupEnv("DVC_CONFIG"); ok {
+		if err := storage.LoadDVCConfig(strings.Split(dvcConfig, ",")...); err != nil {
+			log.
+				WithField("DVC_CONFIG", dvcConfig).
+				WithError(err).
+				Fatal("Cannot load DVC configuration")
+		}
+	}
+	if defaultRemote, ok := os.LookupEnv("DEFAULT_REMOTE"); ok {
+		if err := storage.SetDefault(defaultRemote); err != nil {
+			log.
+				WithField("DEFAULT_REMOTE", defaultRemote).
+				WithError(err).
+				Fatal("Cannot set default remote")
+		}
+	}
+
+	connections := pool.NewPool(getEnvDuration("POOL_TTL", 30*time.Minute))
+	defer connections.Close()
 
 	pathPrefix := os.Getenv("PATH_PREFIX")
 
+	var authentication *auth.Middleware
+	if authConfig, ok := os.LookupEnv("AUTH_CONFIG"); ok {
+		var err error
+		authentication, err = auth.LoadConfig(authConfig)
+		if err != nil {
+			log.
+				WithField("AUTH_CONFIG", authConfig).
+				WithError(err).
+				Fatal("Cannot load authentication configuration")
+		}
+	}
+
 	r := mux.NewRouter()
 
+	if authentication != nil && authentication.Signer != nil {
+		handler.AttachSigning(r, pathPrefix, storage, connections, authentication.Signer)
+	}
+	if enabled, _ := strconv.ParseBool(os.Getenv("WEBDAV_ENABLED")); enabled {
+		handler.AttachWebDAV(r, pathPrefix, storage, connections)
+	}
 	handler.Attach(
 		r,
 		pathPrefix,
 		storage,
+		connections,
 	)
 
+	var root http.Handler = r
+	if authentication != nil {
+		root = authentication.Handler(r)
+	}
+
 	server := http.Server{
 		Addr:              ":8080",
-		Handler:           handlers.LoggingHandler(os.Stderr, r),
+		Handler:           logRequests(root, "signature"),
 		ReadTimeout:       1 * time.Hour,
 		WriteTimeout:      1 * time.Hour,
 		IdleTimeout:       1 * time.Hour,
@@ -59,16 +117,80 @@ func main() {
 		Info("ready")
 
 	go runProfiler()
+	if addr, ok := os.LookupEnv("S3_LISTEN_ADDR"); ok {
+		s3 := s3api.NewServer(storage, connections, s3Credentials())
+		go s3.PurgeUploads(context.Background(), time.Hour, getEnvDuration("S3_MULTIPART_EXPIRY", 24*time.Hour))
+		go runS3(addr, s3)
+	}
+	go trash.Run(context.Background(), storage.Remotes, connections, getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour))
 	err := server.ListenAndServe()
 	if err != nil {
 		panic(err)
 	}
 }
 
+// logRequests writes the access log of h to stderr, with the secrets among
+// the query parameters redacted.
+func logRequests(h http.Handler, secrets ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redacted := r.Clone(r.Context())
+		redacted.URL.RawQuery = auth.RedactQuery(r.URL.RawQuery, secrets...)
+		if i := strings.Index(r.RequestURI, "?"); i >= 0 {
+			redacted.RequestURI = r.RequestURI[:i+1] + auth.RedactQuery(r.RequestURI[i+1:], secrets...)
+		}
+		// The redacted copy is logged, h serves the original request
+		logged := handlers.LoggingHandler(os.Stderr, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			h.ServeHTTP(w, r)
+		}))
+		logged.ServeHTTP(w, redacted)
+	})
+}
+
 func runProfiler() {
 	log.Println(http.ListenAndServe(":7777", nil))
 }
 
+func runS3(addr string, s3 *s3api.Server) {
+	server := http.Server{
+		Addr:              addr,
+		Handler:           logRequests(s3, "X-Amz-Signature", "X-Amz-Security-Token"),
+		ReadTimeout:       1 * time.Hour,
+		WriteTimeout:      1 * time.Hour,
+		IdleTimeout:       1 * time.Hour,
+		ReadHeaderTimeout: 1 * time.Hour,
+	}
+	log.
+		WithField("addr", addr).
+		Info("serving S3 API")
+	log.Fatal(server.ListenAndServe())
+}
+
+// s3Credentials returns the access keys of the S3 API, read from the file
+// S3_CREDENTIALS and from S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY.
+func s3Credentials() []s3api.Credential {
+	var credentials []s3api.Credential
+	if file, ok := os.LookupEnv("S3_CREDENTIALS"); ok {
+		loaded, err := s3api.LoadCredentials(file)
+		if err != nil {
+			log.
+				WithField("S3_CREDENTIALS", file).
+				WithError(err).
+				Fatal("Cannot load S3 credentials")
+		}
+		credentials = loaded
+	}
+	if accessKeyID, ok := os.LookupEnv("S3_ACCESS_KEY_ID"); ok {
+		credentials = append(credentials, s3api.Credential{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: envMustBeSet("S3_SECRET_ACCESS_KEY"),
+		})
+	}
+	if len(credentials) == 0 {
+		log.Fatal("S3_LISTEN_ADDR is set but no S3 credentials are configured")
+	}
+	return credentials
+}
+
 func envMustBeSet(key string) string {
 	value, ok := os.LookupEnv(key)
 	if !ok {
@@ -76,3 +198,11 @@ func envMustBeSet(key string) string {
 	}
 	return value
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrAuthenticationRequired is returned by ACL.Check when an anonymous
+	// request is refused, authenticating may grant the access.
+	ErrAuthenticationRequired = errors.New("authentication required")
+	// ErrForbidden is returned by ACL.Check when an authenticated caller is
+	// refused.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Access is the kind of access a request makes to a remote.
+type Access int
+
+const (
+	// Read covers HEAD and GET, and the endpoints only reading objects.
+	Read Access = iota
+	// Write covers POST, PUT and DELETE.
+	Write
+)
+
+func (a Access) String() string {
+	if a == Write {
+		return "write"
+	}
+	return "read"
+}
+
+// AccessOf returns the access made by an HTTP or WebDAV method.
+func AccessOf(method string) Access {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return Read
+	}
+	return Write
+}
+
+// Principals that are not user names.
+const (
+	// Anonymous matches every request, with credentials or not.
+	Anonymous = "anonymous"
+	// Authenticated matches every authenticated user.
+	Authenticated = "authenticated"
+	// GroupPrefix prefixes the groups, e.g. group:ml.
+	GroupPrefix = "group:"
+)
+
+// ACL lists who may read and who may write a remote. Each principal is a user
+// name, a group as group:<name>, Authenticated or Anonymous. Writers may read
+// too.
+type ACL struct {
+	Read  []string
+	Write []string
+}
+
+// Validate rejects the empty principals and the groups without a name.
+func (acl *ACL) Validate() error {
+	for _, principal := range append(append([]string{}, acl.Read...), acl.Write...) {
+		if strings.TrimPrefix(principal, GroupPrefix) == "" {
+			return fmt.Errorf("invalid principal %q", principal)
+		}
+	}
+	return nil
+}
+
+// Check returns nil when id may make the request r to remote, a remote
+// protected by the ACL. It returns ErrAuthenticationRequired for anonymous
+// requests and ErrForbidden for the others. A nil ACL grants everything.
+//
+// A signed URL grants its own request whatever the ACL, and nothing else: it
+// is refused for another method, object or remote, and when r is nil.
+func (acl *ACL) Check(id *Identity, access Access, r *http.Request, remote string) error {
+	if id != nil && id.Signed != nil {
+		if !id.Signed.Allows(r, remote) || AccessOf(id.Signed.Method) != access {
+			return fmt.Errorf("%w: %s only grants %s %s on remote %q", ErrForbidden, id.User, id.Signed.Method, id.Signed.Path, id.Signed.Remote)
+		}
+		return nil
+	}
+	if acl == nil || matches(id, acl.Write) || access == Read && matches(id, acl.Read) {
+		return nil
+	}
+	if id == nil {
+		return fmt.Errorf("%w to %s", ErrAuthenticationRequired, access)
+	}
+	return fmt.Errorf("%w: %s may not %s", ErrForbidden, id.User, access)
+}
+
+// matches reports whether one of principals designates id.
+func matches(id *Identity, principals []string) bool {
+	for _, principal := range principals {
+		switch {
+		case principal == Anonymous:
+			return true
+		case id == nil:
+			continue
+		case principal == Authenticated, principal == id.User:
+			return true
+		case strings.HasPrefix(principal, GroupPrefix) && id.InGroup(strings.TrimPrefix(principal, GroupPrefix)):
+			return true
+		}
+	}
+	return false
+}
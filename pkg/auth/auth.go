@@ -0,0 +1,175 @@
+// Package auth authenticates the requests made to the proxy and puts the
+// identity of the caller on their context.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request holds
+	// no credentials it understands, so the next one is tried.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned when credentials are given but wrong.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	User   string
+	Groups []string
+	// Method is how the caller authenticated, e.g. basic or token.
+	Method string
+	// Signed is set for the requests of a signed URL, to what the signature
+	// grants. Within that scope the ACL does not restrict them further: the
+	// signer had the access.
+	Signed *SignedScope
+}
+
+// SignedScope is the single request a signed URL grants.
+type SignedScope struct {
+	// Method is GET or PUT, HEAD and POST are signed as those.
+	Method string
+	// Path is the path of the object, e.g. /remote/ab/cdef.
+	Path   string
+	Remote string
+}
+
+// Allows reports whether r, made to remote, is the one the scope grants.
+func (s *SignedScope) Allows(r *http.Request, remote string) bool {
+	return r != nil && signedMethod(r.Method) == s.Method && r.URL.Path == s.Path && remote == s.Remote
+}
+
+// InGroup reports whether the identity belongs to group.
+func (id *Identity) InGroup(group string) bool {
+	for _, g := range id.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+type authenticatorsKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity of the request, or nil for anonymous
+// requests.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
+
+// Authenticator checks the credentials held by a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+	// Challenge is the WWW-Authenticate value sent with a 401.
+	Challenge() string
+}
+
+// Middleware authenticates the requests with the first authenticator finding
+// credentials in them.
+type Middleware struct {
+	Authenticators []Authenticator
+	// Required rejects anonymous requests. Otherwise they reach the handlers
+	// without an identity.
+	Required bool
+	// Groups adds groups to the users, whatever the way they authenticated.
+	Groups map[string][]string
+	// Signer signs temporary URLs, nil when none are configured. It is also
+	// one of the Authenticators.
+	Signer *SignedURLs
+}
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), authenticatorsKey{}, m.Authenticators))
+		for _, authenticator := range m.Authenticators {
+			id, err := authenticator.Authenticate(r)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			if err != nil {
+				// Write an error and stop the handler chain
+				log.
+					WithField("method", r.Method).
+					WithField("path", r.URL.Path).
+					WithError(err).
+					Warn("Authentication failed")
+				m.unauthorized(w, "Invalid credentials")
+				return
+			}
+			id = &Identity{
+				User:   id.User,
+				Groups: append(append([]string{}, id.Groups...), m.Groups[id.User]...),
+				Method: id.Method,
+				Signed: id.Signed,
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+			return
+		}
+
+		if m.Required {
+			// Write an error and stop the handler chain
+			log.
+				WithField("method", r.Method).
+				WithField("path", r.URL.Path).
+				Warn("Authentication required")
+			m.unauthorized(w, "Authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unauthorized writes a 401 listing the ways to authenticate.
+func (m *Middleware) unauthorized(w http.ResponseWriter, message string) {
+	Challenge(w, m.Authenticators)
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// Unauthorized writes a 401 for a request refused by a handler, with the
+// challenges of the middleware that saw the request, if any.
+func Unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	authenticators, _ := r.Context().Value(authenticatorsKey{}).([]Authenticator)
+	Challenge(w, authenticators)
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// Challenge sets the WWW-Authenticate headers of a 401 for authenticators.
+func Challenge(w http.ResponseWriter, authenticators []Authenticator) {
+	for _, authenticator := range authenticators {
+		if challenge := authenticator.Challenge(); challenge != "" {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token := trimBearer(header); token != header {
+		return token
+	}
+	return ""
+}
+
+// trimBearer removes the "Bearer " scheme in front of a token, if any.
+func trimBearer(value string) string {
+	const prefix = "bearer "
+	if len(value) > len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+		return strings.TrimSpace(value[len(prefix):])
+	}
+	return value
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeHtpasswd writes an htpasswd file of alice, whose password is secret.
+func writeHtpasswd(t *testing.T, extra string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# users\n\nalice:" + string(hash) + "\n" + extra
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestHtpasswd(t *testing.T) {
+	htpasswd, err := LoadHtpasswd(writeHtpasswd(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		basic    bool
+		err      error
+	}{
+		{"right password", "alice", "secret", true, nil},
+		{"wrong password", "alice", "guess", true, ErrInvalidCredentials},
+		{"unknown user", "mallory", "secret", true, ErrInvalidCredentials},
+		{"no credentials", "", "", false, ErrNoCredentials},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/remote/ab/cdef", nil)
+		if test.basic {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		id, err := htpasswd.Authenticate(r)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: Authenticate returned %v, want %v", test.name, err, test.err)
+		}
+		if err == nil && (id.User != "alice" || id.Method != "basic") {
+			t.Errorf("%s: identity %+v", test.name, id)
+		}
+	}
+	// Unknown users are compared with a hash as costly as htpasswd -B makes
+	if cost, err := bcrypt.Cost(dummyHash); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost %d, %v", cost, err)
+	}
+	if challenge := htpasswd.Challenge(); challenge != `Basic realm="dvc"` {
+		t.Errorf("challenge %s", challenge)
+	}
+}
+
+func TestLoadHtpasswdRejectsWeakHashes(t *testing.T) {
+	for _, line := range []string{
+		"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n",
+		"bob:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\n",
+		"no hash\n",
+	} {
+		if _, err := LoadHtpasswd(writeHtpasswd(t, line)); err == nil {
+			t.Errorf("LoadHtpasswd accepted %q", line)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	sum := sha256.Sum256([]byte("hashed-token"))
+	tokens, err := NewTokens("X-Dvc-Token", []Token{
+		{User: "ci", Token: "plain-token", Groups: []string{"ml"}},
+		{User: "bot", SHA256: hex.EncodeToString(sum[:])},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		user   string
+		err    error
+	}{
+		{"bearer", http.Header{"Authorization": {"Bearer plain-token"}}, "ci", nil},
+		{"bearer any case", http.Header{"Authorization": {"bearer  plain-token"}}, "ci", nil},
+		{"sha256", http.Header{"Authorization": {"Bearer hashed-token"}}, "bot", nil},
+		{"custom header", http.Header{"X-Dvc-Token": {"plain-token"}}, "ci", nil},
+		{"custom header with scheme", http.Header{"X-Dvc-Token": {"Bearer hashed-token"}}, "bot", nil},
+		{"unknown", http.Header{"Authorization": {"Bearer nope"}}, "", ErrInvalidCredentials},
+		{"basic is not a token", http.Header{"Authorization": {"Basic YWxpY2U6c2VjcmV0"}}, "", ErrNoCredentials},
+		{"no credentials", http.Header{}, "", ErrNoCredentials},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/remote/ab/cdef", nil)
+		r.Header = test.header
+		id, err := tokens.Authenticate(r)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: Authenticate returned %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && (id.User != test.user || id.Method != "token") {
+			t.Errorf("%s: identity %+v, want %s", test.name, id, test.user)
+		}
+	}
+}
+
+func TestNewTokens(t *testing.T) {
+	for name, token := range map[string]Token{
+		"no user":      {Token: "t"},
+		"both":         {User: "u", Token: "t", SHA256: "00"},
+		"neither":      {User: "u"},
+		"short sha256": {User: "u", SHA256: "abcd"},
+		"invalid hex":  {User: "u", SHA256: "zz"},
+	} {
+		if _, err := NewTokens("", []Token{token}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	htpasswd, err := LoadHtpasswd(writeHtpasswd(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewTokens("", []Token{{User: "ci", Token: "plain-token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen *Identity
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	serve := func(m *Middleware, r *http.Request) *httptest.ResponseRecorder {
+		seen = nil
+		w := httptest.NewRecorder()
+		m.Handler(next).ServeHTTP(w, r)
+		return w
+	}
+	challenges := []string{`Basic realm="dvc"`, `Bearer realm="dvc"`}
+	m := &Middleware{
+		Authenticators: []Authenticator{htpasswd, tokens},
+		Groups:         map[string][]string{"alice": {"ml"}},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/remote/ab/cdef", nil)
+	r.SetBasicAuth("alice", "secret")
+	if w := serve(m, r); w.Code != http.StatusNoContent || seen == nil || seen.User != "alice" || !seen.InGroup("ml") {
+		t.Errorf("basic: status %d, identity %+v", w.Code, seen)
+	}
+
+	// htpasswd finds no credentials in a bearer token and lets tokens try
+	r = httptest.NewRequest(http.MethodGet, "/remote/ab/cdef", nil)
+	r.Header.Set("Authorization", "Bearer plain-token")
+	if w := serve(m, r); w.Code != http.StatusNoContent || seen == nil || seen.User != "ci" {
+		t.Errorf("token: status %d, identity %+v", w.Code, seen)
+	}
+
+	// Wrong credentials stop at the first authenticator understanding them
+	r = httptest.NewRequest(http.MethodGet, "/remote/ab/cdef", nil)
+	r.SetBasicAuth("alice", "guess")
+	w := serve(m, r)
+	if w.Code != http.StatusUnauthorized || seen != nil {
+		t.Errorf("wrong password: status %d, identity %+v", w.Code, seen)
+	}
+	if got := w.Header().Values("WWW-Authenticate"); !reflect.DeepEqual(got, challenges) {
+		t.Errorf("wrong password: challenges %q, want %q", got, challenges)
+	}
+
+	// Anonymous requests pass through unless authentication is required
+	r = httptest.NewRequest(http.MethodGet, "/remote/ab/cdef", nil)
+	if w := serve(m, r); w.Code != http.StatusNoContent || seen != nil {
+		t.Errorf("anonymous: status %d, identity %+v", w.Code, seen)
+	}
+	m.Required = true
+	w = serve(m, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous with authentication required: status %d", w.Code)
+	}
+	if got := w.Header().Values("WWW-Authenticate"); !reflect.DeepEqual(got, challenges) {
+		t.Errorf("anonymous with authentication required: challenges %q, want %q", got, challenges)
+	}
+}
+
+func TestUnauthorizedChallenges(t *testing.T) {
+	tokens, err := NewTokens("", []Token{{User: "ci", Token: "plain-token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Middleware{Authenticators: []Authenticator{tokens}}
+	refuse := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Unauthorized(w, r, "Authentication required")
+	})
+
+	w := httptest.NewRecorder()
+	m.Handler(refuse).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/remote/ab/cdef", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="dvc"` {
+		t.Errorf("status %d, challenges %q", w.Code, w.Header().Values("WWW-Authenticate"))
+	}
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/envyaml"
+)
+
+type configFile struct {
+	Required    bool                `yaml:"required"`
+	Htpasswd    string              `yaml:"htpasswd"`
+	TokenHeader string              `yaml:"token_header"`
+	Tokens      []Token             `yaml:"tokens"`
+	JWT         *JWTConfig          `yaml:"jwt"`
+	SignedURLs  *signedURLsConfig   `yaml:"signed_urls"`
+	Groups      map[string][]string `yaml:"groups"`
+}
+
+type signedURLsConfig struct {
+	MaxExpiry time.Duration `yaml:"max_expiry"`
+	Keys      []SigningKey  `yaml:"keys"`
+}
+
+// LoadConfig builds the Middleware described by the YAML file name.
+func LoadConfig(name string) (*Middleware, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read authentication configuration, %w", err)
+	}
+
+	var file configFile
+	if err := envyaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse authentication configuration %s, %w", name, err)
+	}
+
+	m := &Middleware{Required: file.Required, Groups: map[string][]string{}}
+	// The configuration lists the members of each group
+	for group, users := range file.Groups {
+		for _, user := range users {
+			m.Groups[user] = append(m.Groups[user], group)
+		}
+	}
+	if file.Htpasswd != "" {
+		htpasswd, err := LoadHtpasswd(file.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		m.Authenticators = append(m.Authenticators, htpasswd)
+	}
+	if file.SignedURLs != nil {
+		signer, err := NewSignedURLs(file.SignedURLs.Keys, file.SignedURLs.MaxExpiry)
+		if err != nil {
+			return nil, fmt.Errorf("in %s, %w", name, err)
+		}
+		m.Signer = signer
+		m.Authenticators = append(m.Authenticators, signer)
+	}
+	// JWTs go first, the static tokens would reject them as unknown
+	if file.JWT != nil {
+		jwt, err := NewJWT(*file.JWT, file.TokenHeader)
+		if err != nil {
+			return nil, fmt.Errorf("in %s, %w", name, err)
+		}
+		m.Authenticators = append(m.Authenticators, jwt)
+	}
+	if len(file.Tokens) > 0 {
+		tokens, err := NewTokens(file.TokenHeader, file.Tokens)
+		if err != nil {
+			return nil, fmt.Errorf("in %s, %w", name, err)
+		}
+		m.Authenticators = append(m.Authenticators, tokens)
+	}
+	if m.Required && len(m.Authenticators) == 0 {
+		return nil, fmt.Errorf("%s requires authentication but configures no htpasswd, tokens, jwt nor signed_urls", name)
+	}
+	return m, nil
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared with the passwords of unknown users, so that they
+// take as long to refuse as the wrong passwords of known ones. Its cost is
+// the default one of `htpasswd -B`.
+var dummyHash = []byte("$2a$10$iifRxqYYML37lhiXDv2CEu2uRvNQTrYeJ7xr3wBrAHCpQTTOHBkM6")
+
+// Htpasswd authenticates HTTP basic credentials against the bcrypt hashes of
+// an htpasswd file, as written by `htpasswd -B`. DVC sends them with
+// `auth = basic`.
+type Htpasswd struct {
+	Realm  string
+	hashes map[string][]byte
+}
+
+// LoadHtpasswd reads the user:hash lines of the htpasswd file name. Only
+// bcrypt hashes are accepted, the other schemes of htpasswd are weak.
+func LoadHtpasswd(name string) (*Htpasswd, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read htpasswd file, %w", err)
+	}
+	defer file.Close()
+
+	h := &Htpasswd{Realm: "dvc", hashes: map[string][]byte{}}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d of %s is not user:hash", line, name)
+		}
+		user, hash := text[:i], text[i+1:]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d of %s, the hash of %s is not bcrypt", line, name, user)
+		}
+		h.hashes[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read htpasswd file, %w", err)
+	}
+	return h, nil
+}
+
+func (h *Htpasswd) Authenticate(r *http.Request) (*Identity, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	hash, ok := h.hashes[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, fmt.Errorf("%w: unknown user %s", ErrInvalidCredentials, user)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return nil, fmt.Errorf("%w: wrong password for %s", ErrInvalidCredentials, user)
+	}
+	return &Identity{User: user, Method: "basic"}, nil
+}
+
+func (h *Htpasswd) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q", h.Realm)
+}
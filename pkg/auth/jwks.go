@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefresh bounds how often an unknown key id reloads the key set, so that
+// tokens signed by unknown keys do not hammer the identity provider.
+const minRefresh = time.Minute
+
+// jwk is a public JSON Web Key, as listed in a JWKS.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a key of the set, with the algorithm it is restricted to.
+type publicKey struct {
+	alg string
+	key interface{}
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	decode := func(name, value string) ([]byte, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid %s", name)
+		}
+		return b, nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid e")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid x")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// parseJWKS returns the signing keys of a JWKS by key id.
+func parseJWKS(content []byte) (map[string]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("cannot parse JWKS, %w", err)
+	}
+
+	keys := map[string]publicKey{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d of the JWKS (kid %q), %w", i, k.Kid, err)
+		}
+		keys[k.Kid] = publicKey{alg: k.Alg, key: key}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("the JWKS has no signing key")
+	}
+	return keys, nil
+}
+
+// keySet is a JWKS read from a file or a URL. It is reloaded once Refresh is
+// over, and when a token names a key it does not know, to follow the key
+// rotations of the identity provider.
+type keySet struct {
+	File    string
+	URL     string
+	Refresh time.Duration
+	Client  *http.Client
+
+	// loading serializes the reloads, which do not hold mu so that the
+	// tokens signed by known keys are verified meanwhile.
+	loading sync.Mutex
+
+	mu      sync.Mutex
+	keys    map[string]publicKey
+	fetched time.Time
+}
+
+// key returns the key kid, or the only key of the set when kid is empty.
+func (s *keySet) key(kid string) (publicKey, error) {
+	if s.stale(kid) {
+		if err := s.refresh(kid); err != nil {
+			s.mu.Lock()
+			loaded := s.keys != nil
+			s.mu.Unlock()
+			if !loaded {
+				return publicKey{}, err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return publicKey{}, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// stale reports whether the set must be read again before looking kid up.
+func (s *keySet) stale(kid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	age := time.Since(s.fetched)
+	_, known := s.keys[kid]
+	return s.keys == nil || s.Refresh > 0 && age > s.Refresh || !known && kid != "" && age > minRefresh
+}
+
+// refresh reads the set again, unless another request did while this one
+// was waiting.
+func (s *keySet) refresh(kid string) error {
+	s.loading.Lock()
+	defer s.loading.Unlock()
+	if !s.stale(kid) {
+		return nil
+	}
+	return s.load()
+}
+
+// load reads the set again. The previous keys stay in use when it fails.
+func (s *keySet) load() error {
+	keys, err := s.fetch()
+
+	// Dated once done, so that the requests arriving meanwhile wait for
+	// the keys instead of finding the set fresh
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetched = time.Now()
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	return nil
+}
+
+func (s *keySet) fetch() (map[string]publicKey, error) {
+	var content []byte
+	var err error
+	if s.File != "" {
+		content, err = os.ReadFile(s.File)
+	} else {
+		content, err = s.get(s.URL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read JWKS, %w", err)
+	}
+	return parseJWKS(content)
+}
+
+// newJWKSClient returns the client fetching the OpenID configuration and the
+// JWKS. Its transport is its own and always verifies the certificates, against
+// the PEM bundle caFile when given, or the system roots.
+func newJWKSClient(caFile string) (*http.Client, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read jwt ca_file, %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("jwt ca_file %s holds no PEM certificate", caFile)
+		}
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     config,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}, nil
+}
+
+func (s *keySet) get(url string) ([]byte, error) {
+	response, err := s.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// discoverJWKS returns the jwks_uri of the OpenID configuration of issuer.
+func discoverJWKS(client *http.Client, issuer string) (string, error) {
+	s := &keySet{Client: client}
+	content, err := s.get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("cannot discover the JWKS of %s, %w", issuer, err)
+	}
+	var configuration struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(content, &configuration); err != nil || configuration.JWKSURI == "" {
+		return "", fmt.Errorf("the OpenID configuration of %s has no jwks_uri", issuer)
+	}
+	return configuration.JWKSURI, nil
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingMethods are the asymmetric algorithms accepted for JWTs. Shared
+// secrets have no place in a JWKS.
+var signingMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// ClaimGroups adds Groups to the callers whose token holds Claim with Value,
+// or Value among its values.
+type ClaimGroups struct {
+	Claim  string   `yaml:"claim"`
+	Value  string   `yaml:"value"`
+	Groups []string `yaml:"groups"`
+}
+
+// JWTConfig describes who issues the JWTs and how their claims map to an
+// identity.
+type JWTConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// JWKSFile or JWKSURL give the keys of the issuer. Without them, the
+	// keys are found by OpenID discovery on Issuer.
+	JWKSFile string        `yaml:"jwks_file"`
+	JWKSURL  string        `yaml:"jwks_url"`
+	Refresh  time.Duration `yaml:"refresh"`
+	// CAFile is a PEM bundle of the authorities trusted to serve the
+	// discovery and JWKS URLs, instead of the system ones.
+	CAFile string `yaml:"ca_file"`
+	// Leeway tolerates clock skew on exp and nbf.
+	Leeway time.Duration `yaml:"leeway"`
+	// UserClaim names the user, sub by default.
+	UserClaim string `yaml:"user_claim"`
+	// GroupsClaim holds groups of the user, as a string or a list.
+	GroupsClaim string        `yaml:"groups_claim"`
+	ClaimGroups []ClaimGroups `yaml:"claim_groups"`
+}
+
+// JWT authenticates the JWTs given as bearer tokens, in an "Authorization:
+// Bearer" header or in Header. Their signature, issuer, audience and expiry
+// are checked.
+type JWT struct {
+	Config JWTConfig
+	Header string
+	keys   *keySet
+	now    func() time.Time
+}
+
+// NewJWT checks the configuration and reads the keys of the issuer, so that a
+// wrong JWKS is found at startup.
+func NewJWT(config JWTConfig, header string) (*JWT, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("jwt requires an issuer and an audience")
+	}
+	if config.JWKSFile != "" && config.JWKSURL != "" {
+		return nil, errors.New("jwt has both a jwks_file and a jwks_url")
+	}
+	if config.UserClaim == "" {
+		config.UserClaim = "sub"
+	}
+	if config.Leeway == 0 {
+		config.Leeway = time.Minute
+	}
+
+	client, err := newJWKSClient(config.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	keys := &keySet{File: config.JWKSFile, URL: config.JWKSURL, Refresh: config.Refresh, Client: client}
+	if keys.File == "" {
+		if keys.URL == "" {
+			url, err := discoverJWKS(client, config.Issuer)
+			if err != nil {
+				return nil, err
+			}
+			keys.URL = url
+		}
+		if keys.Refresh == 0 {
+			keys.Refresh = time.Hour
+		}
+	}
+	if err := keys.load(); err != nil {
+		return nil, err
+	}
+	return &JWT{Config: config, Header: header, keys: keys, now: time.Now}, nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Identity, error) {
+	value := bearerToken(r)
+	if value == "" && j.Header != "" {
+		value = trimBearer(strings.TrimSpace(r.Header.Get(j.Header)))
+	}
+	// Bearer tokens that are not JWTs are left to the other authenticators
+	if strings.Count(value, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(signingMethods), jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(value, claims, j.keyFunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if err := j.validate(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	user, _ := claims[j.Config.UserClaim].(string)
+	if user == "" {
+		return nil, fmt.Errorf("%w: the token has no %s claim", ErrInvalidCredentials, j.Config.UserClaim)
+	}
+	return &Identity{User: user, Groups: j.groups(claims), Method: "jwt"}, nil
+}
+
+func (j *JWT) Challenge() string {
+	return `Bearer realm="dvc"`
+}
+
+// keyFunc returns the key of the JWKS that signed the token.
+func (j *JWT) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := j.keys.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("key %q is for %s, not %s", kid, key.alg, token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+// validate checks the registered claims of a token whose signature is valid.
+func (j *JWT) validate(claims jwt.MapClaims) error {
+	now := j.now()
+	if !claims.VerifyExpiresAt(now.Add(-j.Config.Leeway).Unix(), true) {
+		return errors.New("the token is expired or has no exp")
+	}
+	if !claims.VerifyNotBefore(now.Add(j.Config.Leeway).Unix(), false) {
+		return errors.New("the token is not valid yet")
+	}
+	if !claims.VerifyIssuer(j.Config.Issuer, true) {
+		return fmt.Errorf("the token is not issued by %s", j.Config.Issuer)
+	}
+	if !claims.VerifyAudience(j.Config.Audience, true) {
+		return fmt.Errorf("the token is not for %s", j.Config.Audience)
+	}
+	return nil
+}
+
+// groups returns the groups of GroupsClaim and those granted by ClaimGroups.
+func (j *JWT) groups(claims jwt.MapClaims) []string {
+	var groups []string
+	if j.Config.GroupsClaim != "" {
+		groups = append(groups, claimValues(claims[j.Config.GroupsClaim])...)
+	}
+	for _, mapping := range j.Config.ClaimGroups {
+		for _, value := range claimValues(claims[mapping.Claim]) {
+			if value == mapping.Value {
+				groups = append(groups, mapping.Groups...)
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// claimValues returns the strings of a claim holding a string or a list.
+func claimValues(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testAudience = "dvc"
+)
+
+// identityProvider serves an OpenID configuration and a JWKS over TLS.
+type identityProvider struct {
+	server *httptest.Server
+	caFile string
+
+	mu       sync.Mutex
+	keys     []jwk
+	requests int
+}
+
+func newIdentityProvider(t *testing.T) *identityProvider {
+	t.Helper()
+	idp := &identityProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": testIssuer, "jwks_uri": idp.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.requests++
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": idp.keys})
+	})
+	idp.server = httptest.NewUnstartedServer(mux)
+	// Refused handshakes are expected, do not log them
+	idp.server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	idp.server.StartTLS()
+	t.Cleanup(idp.server.Close)
+
+	idp.caFile = filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: idp.server.Certificate().Raw})
+	if err := os.WriteFile(idp.caFile, certificate, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return idp
+}
+
+func (idp *identityProvider) publish(keys ...jwk) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.keys = keys
+}
+
+func (idp *identityProvider) jwksRequests() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.requests
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) jwk {
+	return jwk{Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256", N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) jwk {
+	return jwk{Kty: "EC", Kid: kid, Crv: "P-256", X: encode(key.X.FillBytes(make([]byte, 32))), Y: encode(key.Y.FillBytes(make([]byte, 32)))}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func claims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":    testIssuer,
+		"aud":    testAudience,
+		"sub":    "alice",
+		"groups": []string{"ml"},
+		"exp":    now.Add(time.Hour).Unix(),
+		"iat":    now.Unix(),
+	}
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/remote/ab/cd", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := newIdentityProvider(t)
+	idp.publish(rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey))
+
+	verifier, err := NewJWT(JWTConfig{Issuer: idp.server.URL, Audience: testAudience, CAFile: idp.caFile, GroupsClaim: "groups"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.Config.Issuer = testIssuer
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	with := func(change func(c jwt.MapClaims)) jwt.MapClaims {
+		c := claims(now)
+		change(c)
+		return c
+	}
+	// The public key of the JWKS, used as an HMAC secret
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims(now)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"rsa", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(now)), nil},
+		{"ec", sign(t, jwt.SigningMethodES256, "ec", ecKey, claims(now)), nil},
+		{"bad signature", sign(t, jwt.SigningMethodRS256, "rsa", otherKey, claims(now)), ErrInvalidCredentials},
+		{"wrong key algorithm", sign(t, jwt.SigningMethodRS384, "rsa", rsaKey, claims(now)), ErrInvalidCredentials},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["iss"] = "https://other.test" })), ErrInvalidCredentials},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["aud"] = "other" })), ErrInvalidCredentials},
+		{"audience list", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["aud"] = []string{"other", testAudience} })), nil},
+		{"expired within leeway", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["exp"] = now.Add(-30 * time.Second).Unix() })), nil},
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), ErrInvalidCredentials},
+		{"no expiry", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { delete(c, "exp") })), ErrInvalidCredentials},
+		{"not valid yet", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["nbf"] = now.Add(2 * time.Minute).Unix() })), ErrInvalidCredentials},
+		{"no subject", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { delete(c, "sub") })), ErrInvalidCredentials},
+		{"alg none", unsigned, ErrInvalidCredentials},
+		{"HS256 with the public key", sign(t, jwt.SigningMethodHS256, "rsa", rsaPublic, claims(now)), ErrInvalidCredentials},
+		{"not a JWT", "opaque-token", ErrNoCredentials},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := verifier.Authenticate(bearer(test.token))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Authenticate returned %v, want %v", err, test.err)
+			}
+			if err == nil && (id.User != "alice" || !id.InGroup("ml") || id.Method != "jwt") {
+				t.Errorf("identity %+v", id)
+			}
+		})
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := newIdentityProvider(t)
+	idp.publish(rsaJWK("old", oldKey))
+
+	verifier, err := NewJWT(JWTConfig{Issuer: testIssuer, Audience: testAudience, JWKSURL: idp.server.URL + "/jwks", CAFile: idp.caFile}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := idp.jwksRequests(); n != 1 {
+		t.Fatalf("%d JWKS requests at startup, want 1", n)
+	}
+	token := sign(t, jwt.SigningMethodES256, "new", newKey, claims(time.Now()))
+
+	// Unknown keys reload the set at most once a minute
+	idp.publish(rsaJWK("old", oldKey), ecJWK("new", newKey))
+	if _, err := verifier.Authenticate(bearer(token)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate returned %v before the set may be reloaded", err)
+	}
+	if n := idp.jwksRequests(); n != 1 {
+		t.Fatalf("%d JWKS requests, the set was reloaded too early", n)
+	}
+
+	verifier.keys.mu.Lock()
+	verifier.keys.fetched = time.Now().Add(-2 * minRefresh)
+	verifier.keys.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := verifier.Authenticate(bearer(token)); err != nil {
+				t.Errorf("the rotated key is not used, %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := idp.jwksRequests(); n != 2 {
+		t.Errorf("%d JWKS requests, want one reload", n)
+	}
+}
+
+func TestJWTVerifiesCertificates(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := newIdentityProvider(t)
+	idp.publish(ecJWK("ec", key))
+
+	// The proxy skips verification on the default transport, the JWKS
+	// client must not inherit it
+	transport := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = transport })
+	http.DefaultTransport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+
+	if _, err := NewJWT(JWTConfig{Issuer: testIssuer, Audience: testAudience, JWKSURL: idp.server.URL + "/jwks"}, ""); err == nil {
+		t.Error("the self-signed certificate of the JWKS URL was accepted")
+	}
+	if _, err := NewJWT(JWTConfig{Issuer: idp.server.URL, Audience: testAudience}, ""); err == nil {
+		t.Error("the self-signed certificate of the issuer was accepted")
+	}
+	if _, err := NewJWT(JWTConfig{Issuer: testIssuer, Audience: testAudience, JWKSURL: idp.server.URL + "/jwks", CAFile: idp.caFile}, ""); err != nil {
+		t.Errorf("the certificate signed by ca_file was refused, %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultMaxExpiry = 24 * time.Hour
+
+// SigningKey is a secret signing temporary URLs. ID goes in the URLs, so that
+// the key verifying them is found after a rotation.
+type SigningKey struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+// SignedURLs signs and verifies temporary URLs of single objects. A URL is
+// scoped to a method, a path and a remote, its query holds expires, key_id
+// and signature.
+//
+// The first key signs the new URLs, every key verifies, so rotating a key is
+// adding a new one in front and removing the old one once its URLs expired.
+type SignedURLs struct {
+	Keys []SigningKey
+	// MaxExpiry is the longest lifetime of a URL. Lowering it also shortens
+	// the URLs already given.
+	MaxExpiry time.Duration
+	now       func() time.Time
+}
+
+// NewSignedURLs checks the keys.
+func NewSignedURLs(keys []SigningKey, maxExpiry time.Duration) (*SignedURLs, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("signed_urls has no keys")
+	}
+	seen := map[string]bool{}
+	for i, key := range keys {
+		if key.ID == "" || strings.ContainsAny(key.ID, "&=?#") {
+			return nil, fmt.Errorf("signing key #%d has no valid id", i)
+		}
+		if len(key.Secret) < 16 {
+			return nil, fmt.Errorf("signing key %s is shorter than 16 bytes", key.ID)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("signing key %s is declared twice", key.ID)
+		}
+		seen[key.ID] = true
+	}
+	if maxExpiry <= 0 {
+		maxExpiry = defaultMaxExpiry
+	}
+	return &SignedURLs{Keys: keys, MaxExpiry: maxExpiry, now: time.Now}, nil
+}
+
+// Sign returns the query granting method on path of remote until expires.
+func (s *SignedURLs) Sign(method, path, remote string, expires time.Time) url.Values {
+	key := s.Keys[0]
+	query := url.Values{}
+	if remote != "" {
+		query.Set("remote", remote)
+	}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("key_id", key.ID)
+	query.Set("signature", s.signature(key, method, path, remote, query.Get("expires")))
+	return query
+}
+
+func (s *SignedURLs) Authenticate(r *http.Request) (*Identity, error) {
+	query := r.URL.Query()
+	signature := query.Get("signature")
+	if signature == "" {
+		return nil, ErrNoCredentials
+	}
+
+	keyID := query.Get("key_id")
+	var key *SigningKey
+	for i := range s.Keys {
+		if s.Keys[i].ID == keyID {
+			key = &s.Keys[i]
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidCredentials, keyID)
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid expires", ErrInvalidCredentials)
+	}
+	now := s.now()
+	if now.Unix() > expires {
+		return nil, fmt.Errorf("%w: the URL expired at %s", ErrInvalidCredentials, time.Unix(expires, 0).UTC().Format(time.RFC3339))
+	}
+	if time.Unix(expires, 0).After(now.Add(s.MaxExpiry)) {
+		return nil, fmt.Errorf("%w: the URL expires after the longest lifetime", ErrInvalidCredentials)
+	}
+
+	expected := s.signature(*key, r.Method, r.URL.Path, query.Get("remote"), query.Get("expires"))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, fmt.Errorf("%w: wrong signature for %s %s", ErrInvalidCredentials, r.Method, r.URL.Path)
+	}
+	return &Identity{
+		User:   "signed-url:" + key.ID,
+		Method: "signed-url",
+		Signed: &SignedScope{Method: signedMethod(r.Method), Path: r.URL.Path, Remote: query.Get("remote")},
+	}, nil
+}
+
+// RedactQuery replaces the values of the named parameters of a raw query, so
+// that the URLs can be logged without the signatures granting access.
+func RedactQuery(rawQuery string, names ...string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		raw := pair
+		if j := strings.Index(pair, "="); j >= 0 {
+			raw = pair[:j]
+		}
+		name, err := url.QueryUnescape(raw)
+		if err != nil {
+			name = raw
+		}
+		for _, secret := range names {
+			if strings.EqualFold(name, secret) {
+				pairs[i] = raw + "=REDACTED"
+				break
+			}
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (s *SignedURLs) Challenge() string {
+	return ""
+}
+
+// signature is the hex HMAC-SHA256 of the scope of a URL.
+func (s *SignedURLs) signature(key SigningKey, method, path, remote, expires string) string {
+	mac := hmac.New(sha256.New, []byte(key.Secret))
+	mac.Write([]byte(strings.Join([]string{signedMethod(method), path, remote, expires, key.ID}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedMethod is the method a request is signed as. HEAD is signed as GET
+// and POST as PUT, since both reach the same handlers.
+func signedMethod(method string) string {
+	switch method {
+	case http.MethodHead:
+		return http.MethodGet
+	case http.MethodPost:
+		return http.MethodPut
+	}
+	return method
+}
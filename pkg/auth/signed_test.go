@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignedURLs(t *testing.T) {
+	signer, err := NewSignedURLs([]SigningKey{{ID: "2022", Secret: "0123456789abcdef"}, {ID: "2021", Secret: "fedcba9876543210"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	signer.now = func() time.Time { return now }
+	query := signer.Sign(http.MethodGet, "/remote/ab/cdef", "datasets", now.Add(30*time.Minute))
+
+	// Keys rotated out still verify the URLs they signed
+	rotated := &SignedURLs{Keys: signer.Keys[1:], MaxExpiry: time.Hour}
+	oldQuery := rotated.Sign(http.MethodPut, "/remote/ab/cdef", "", now.Add(30*time.Minute))
+
+	with := func(query url.Values, name, value string) url.Values {
+		changed := url.Values{}
+		for k, v := range query {
+			changed[k] = v
+		}
+		changed.Set(name, value)
+		return changed
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		query  url.Values
+		now    time.Time
+		err    error
+	}{
+		{"get", http.MethodGet, "/remote/ab/cdef", query, now, nil},
+		{"head as get", http.MethodHead, "/remote/ab/cdef", query, now, nil},
+		{"key rotated out", http.MethodPut, "/remote/ab/cdef", oldQuery, now, nil},
+		{"post as put", http.MethodPost, "/remote/ab/cdef", oldQuery, now, nil},
+		{"tampered method", http.MethodPut, "/remote/ab/cdef", query, now, ErrInvalidCredentials},
+		{"tampered delete", http.MethodDelete, "/remote/ab/cdef", query, now, ErrInvalidCredentials},
+		{"tampered path", http.MethodGet, "/remote/ab/cdeg", query, now, ErrInvalidCredentials},
+		{"tampered remote", http.MethodGet, "/remote/ab/cdef", with(query, "remote", "private"), now, ErrInvalidCredentials},
+		{"remote dropped", http.MethodGet, "/remote/ab/cdef", with(query, "remote", ""), now, ErrInvalidCredentials},
+		{"tampered expiry", http.MethodGet, "/remote/ab/cdef", with(query, "expires", "1651408000"), now, ErrInvalidCredentials},
+		{"tampered signature", http.MethodGet, "/remote/ab/cdef", with(query, "signature", "00"+query.Get("signature")[2:]), now, ErrInvalidCredentials},
+		{"expired", http.MethodGet, "/remote/ab/cdef", query, now.Add(31 * time.Minute), ErrInvalidCredentials},
+		{"unknown key", http.MethodGet, "/remote/ab/cdef", with(query, "key_id", "2020"), now, ErrInvalidCredentials},
+		{"key of another URL", http.MethodGet, "/remote/ab/cdef", with(query, "key_id", "2021"), now, ErrInvalidCredentials},
+		{"invalid expiry", http.MethodGet, "/remote/ab/cdef", with(query, "expires", "soon"), now, ErrInvalidCredentials},
+		{"unsigned", http.MethodGet, "/remote/ab/cdef", url.Values{"remote": {"datasets"}}, now, ErrNoCredentials},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signer.now = func() time.Time { return test.now }
+			r := httptest.NewRequest(test.method, test.path+"?"+test.query.Encode(), nil)
+			id, err := signer.Authenticate(r)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Authenticate returned %v, want %v", err, test.err)
+			}
+			if err == nil && id.Signed == nil {
+				t.Errorf("identity %+v is not signed", id)
+			}
+		})
+	}
+}
+
+func TestSignedURLScope(t *testing.T) {
+	signer, err := NewSignedURLs([]SigningKey{{ID: "k", Secret: "0123456789abcdef"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	signer.now = func() time.Time { return now }
+	query := signer.Sign(http.MethodGet, "/remote/ab/cdef", "datasets", now.Add(30*time.Minute))
+	url := "/remote/ab/cdef?" + query.Encode()
+
+	id, err := signer.Authenticate(httptest.NewRequest(http.MethodGet, url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ACL lets nobody in, the signed URL grants its own request anyway
+	acl := &ACL{Write: []string{"alice"}}
+
+	tests := []struct {
+		name   string
+		access Access
+		r      *http.Request
+		remote string
+		err    error
+	}{
+		{"get", Read, httptest.NewRequest(http.MethodGet, url, nil), "datasets", nil},
+		{"head", Read, httptest.NewRequest(http.MethodHead, url, nil), "datasets", nil},
+		{"put", Write, httptest.NewRequest(http.MethodPut, url, nil), "datasets", ErrForbidden},
+		{"delete", Write, httptest.NewRequest(http.MethodDelete, url, nil), "datasets", ErrForbidden},
+		{"write access", Write, httptest.NewRequest(http.MethodGet, url, nil), "datasets", ErrForbidden},
+		{"another key", Read, httptest.NewRequest(http.MethodGet, "/remote/ab/cdeg?"+query.Encode(), nil), "datasets", ErrForbidden},
+		{"another remote", Read, httptest.NewRequest(http.MethodGet, url, nil), "private", ErrForbidden},
+		{"no request", Read, nil, "datasets", ErrForbidden},
+	}
+	for _, test := range tests {
+		if err := acl.Check(id, test.access, test.r, test.remote); !errors.Is(err, test.err) {
+			t.Errorf("%s: Check returned %v, want %v", test.name, err, test.err)
+		}
+	}
+	// Without an ACL too, the URL grants nothing but its own request
+	var open *ACL
+	if err := open.Check(id, Write, httptest.NewRequest(http.MethodPut, url, nil), "datasets"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Check of a PUT on a remote without ACL returned %v", err)
+	}
+
+	signer.now = func() time.Time { return now.Add(31 * time.Minute) }
+	if _, err := signer.Authenticate(httptest.NewRequest(http.MethodGet, url, nil)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate returned %v after expiry", err)
+	}
+}
+
+func TestSignedURLsMaxExpiry(t *testing.T) {
+	signer, err := NewSignedURLs([]SigningKey{{ID: "k", Secret: "0123456789abcdef"}}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	query := signer.Sign(http.MethodGet, "/remote/ab/cdef", "", now.Add(12*time.Hour))
+
+	// Lowering the longest lifetime shortens the URLs already given
+	signer.MaxExpiry = time.Hour
+	r := httptest.NewRequest(http.MethodGet, "/remote/ab/cdef?"+query.Encode(), nil)
+	if _, err := signer.Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate returned %v for a URL outliving MaxExpiry", err)
+	}
+}
+
+func TestNewSignedURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []SigningKey
+	}{
+		{"no keys", nil},
+		{"no id", []SigningKey{{Secret: "0123456789abcdef"}}},
+		{"id breaking the query", []SigningKey{{ID: "a&b", Secret: "0123456789abcdef"}}},
+		{"short secret", []SigningKey{{ID: "k", Secret: "short"}}},
+		{"duplicate id", []SigningKey{{ID: "k", Secret: "0123456789abcdef"}, {ID: "k", Secret: "fedcba9876543210"}}},
+	}
+	for _, test := range tests {
+		if _, err := NewSignedURLs(test.keys, 0); err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"", ""},
+		{"remote=datasets", "remote=datasets"},
+		{"remote=datasets&expires=1&key_id=k&signature=abcd", "remote=datasets&expires=1&key_id=k&signature=REDACTED"},
+		{"signature=abcd&signature=efgh", "signature=REDACTED&signature=REDACTED"},
+		{"Signature=abcd&sig%6eature=efgh&signatures=x", "Signature=REDACTED&sig%6eature=REDACTED&signatures=x"},
+		{"signature", "signature=REDACTED"},
+	}
+	for _, test := range tests {
+		if got := RedactQuery(test.query, "signature"); got != test.want {
+			t.Errorf("RedactQuery(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+// Package bucketfs exposes a blob.Bucket as a webdav.FileSystem.
+//
+// Blob stores have no directories: a directory exists as long as some key
+// starts with its path. Directories created empty with Mkdir only live in the
+// memory of the process.
+package bucketfs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/net/webdav"
+)
+
+type contextKey int
+
+// expectedSizeKey holds the Content-Length of a PUT, see WithExpectedSize.
+const expectedSizeKey contextKey = iota
+
+// WithExpectedSize tells the files opened for writing with ctx how many bytes
+// are coming. A file closed before receiving them all is not committed.
+func WithExpectedSize(ctx context.Context, size int64) context.Context {
+	return context.WithValue(ctx, expectedSizeKey, size)
+}
+
+// Remover takes key out of the bucket, e.g. by moving it to a trash.
+type Remover func(ctx context.Context, bucket *blob.Bucket, key string) error
+
+// FS is a webdav.FileSystem backed by a bucket.
+type FS struct {
+	Bucket *blob.Bucket
+	// Hidden are top level folders kept out of sight, e.g. the trash.
+	Hidden []string
+	// Remove deletes an object, Bucket.Delete is used when nil.
+	Remove Remover
+	// Dirs keeps the empty directories created with Mkdir.
+	Dirs *Dirs
+}
+
+// Dirs remembers the directories created empty. It is shared by the FS of a
+// same remote.
+type Dirs struct {
+	mu    sync.Mutex
+	names map[string]time.Time
+}
+
+func NewDirs() *Dirs {
+	return &Dirs{names: map[string]time.Time{}}
+}
+
+func (d *Dirs) add(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.names[key] = time.Now()
+}
+
+func (d *Dirs) get(key string) (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.names[key]
+	return t, ok
+}
+
+// remove forgets key and the directories below it.
+func (d *Dirs) remove(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.names {
+		if name == key || strings.HasPrefix(name, key+"/") {
+			delete(d.names, name)
+		}
+	}
+}
+
+// children returns the remembered directories directly under key.
+func (d *Dirs) children(key string) map[string]time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	children := map[string]time.Time{}
+	for name, t := range d.names {
+		if parent := path.Dir(name); parent == key || (key == "" && parent == ".") {
+			children[name] = t
+		}
+	}
+	return children
+}
+
+// ContentMD5 returns the MD5 a DVC object key is derived from, that is a key
+// ending in two hexadecimal segments making 32 characters, or nil.
+func ContentMD5(key string) []byte {
+	dir, file := path.Split(strings.TrimSuffix(key, ".dir"))
+	checksum := path.Base(dir) + file
+	if len(checksum) != 32 {
+		return nil
+	}
+	sum, err := hex.DecodeString(checksum)
+	if err != nil {
+		return nil
+	}
+	return sum
+}
+
+func toKey(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+func (fs *FS) hidden(key string) bool {
+	for _, folder := range fs.Hidden {
+		if folder != "" && (key == folder || strings.HasPrefix(key, folder+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *FS) remove(ctx context.Context, key string) error {
+	if fs.Remove != nil {
+		return fs.Remove(ctx, fs.Bucket, key)
+	}
+	return fs.Bucket.Delete(ctx, key)
+}
+
+// isDir reports whether some object lives under key.
+func (fs *FS) isDir(ctx context.Context, key string) (bool, error) {
+	if key == "" {
+		return true, nil
+	}
+	if _, ok := fs.Dirs.get(key); ok {
+		return true, nil
+	}
+	objects, _, err := fs.Bucket.ListPage(ctx, blob.FirstPageToken, 1, &blob.ListOptions{Prefix: key + "/"})
+	if err != nil {
+		return false, err
+	}
+	return len(objects) > 0, nil
+}
+
+func (fs *FS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	key := toKey(name)
+	if key == "" || fs.hidden(key) {
+		return os.ErrExist
+	}
+	if _, err := fs.Stat(ctx, name); err == nil {
+		return os.ErrExist
+	}
+	if parent := path.Dir(key); parent != "." {
+		if ok, err := fs.isDir(ctx, parent); err != nil || !ok {
+			return os.ErrNotExist
+		}
+	}
+	fs.Dirs.add(key)
+	return nil
+}
+
+func (fs *FS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	key := toKey(name)
+	if fs.hidden(key) {
+		return nil, os.ErrNotExist
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if key == "" {
+			return nil, os.ErrInvalid
+		}
+		if ok, _ := fs.isDir(ctx, key); ok {
+			return nil, os.ErrInvalid
+		}
+		return fs.create(ctx, key)
+	}
+
+	info, err := fs.stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{fs: fs, ctx: ctx, key: key, info: info}
+	if !info.dir {
+		f.reader = NewReadSeeker(ctx, fs.Bucket, key, info.size)
+	}
+	return f, nil
+}
+
+func (fs *FS) create(ctx context.Context, key string) (webdav.File, error) {
+	expected := int64(-1)
+	if size, ok := ctx.Value(expectedSizeKey).(int64); ok {
+		expected = size
+	}
+
+	writeCtx, abort := context.WithCancel(ctx)
+	writer, err := fs.Bucket.NewWriter(writeCtx, key, &blob.WriterOptions{
+		ContentMD5: ContentMD5(key),
+	})
+	if err != nil {
+		abort()
+		return nil, err
+	}
+	return &file{
+		fs:       fs,
+		ctx:      ctx,
+		key:      key,
+		info:     &fileInfo{name: path.Base(key), modTime: time.Now()},
+		writer:   writer,
+		abort:    abort,
+		expected: expected,
+	}, nil
+}
+
+func (fs *FS) RemoveAll(ctx context.Context, name string) error {
+	key := toKey(name)
+	if key == "" || fs.hidden(key) {
+		return os.ErrPermission
+	}
+
+	errRemove := fs.remove(ctx, key)
+	if errRemove == nil {
+		return nil
+	}
+	if gcerrors.Code(errRemove) != gcerrors.NotFound {
+		return errRemove
+	}
+
+	fs.Dirs.remove(key)
+	iter := fs.Bucket.List(&blob.ListOptions{Prefix: key + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fs.remove(ctx, obj.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+	}
+}
+
+func (fs *FS) Rename(ctx context.Context, oldName, newName string) error {
+	oldKey, newKey := toKey(oldName), toKey(newName)
+	if oldKey == "" || newKey == "" || fs.hidden(oldKey) || fs.hidden(newKey) {
+		return os.ErrPermission
+	}
+	if strings.HasPrefix(newKey, oldKey+"/") {
+		return fmt.Errorf("cannot move %s under itself", oldName)
+	}
+
+	errMove := fs.move(ctx, oldKey, newKey)
+	if errMove == nil {
+		return nil
+	}
+	if gcerrors.Code(errMove) != gcerrors.NotFound {
+		return errMove
+	}
+
+	// Not an object, move everything under it.
+	if _, ok := fs.Dirs.get(oldKey); ok {
+		fs.Dirs.remove(oldKey)
+		fs.Dirs.add(newKey)
+	}
+	iter := fs.Bucket.List(&blob.ListOptions{Prefix: oldKey + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fs.move(ctx, obj.Key, newKey+strings.TrimPrefix(obj.Key, oldKey)); err != nil {
+			return err
+		}
+	}
+}
+
+// move copies oldKey to newKey, then removes oldKey like a delete does, so
+// a moved object can be restored from the trash too.
+func (fs *FS) move(ctx context.Context, oldKey, newKey string) error {
+	if err := fs.Bucket.Copy(ctx, newKey, oldKey, nil); err != nil {
+		return err
+	}
+	return fs.remove(ctx, oldKey)
+}
+
+func (fs *FS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	key := toKey(name)
+	if fs.hidden(key) {
+		return nil, os.ErrNotExist
+	}
+	return fs.stat(ctx, key)
+}
+
+func (fs *FS) stat(ctx context.Context, key string) (*fileInfo, error) {
+	if key == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+
+	attrs, err := fs.Bucket.Attributes(ctx, key)
+	if err == nil {
+		return &fileInfo{
+			name:        path.Base(key),
+			size:        attrs.Size,
+			modTime:     attrs.ModTime,
+			contentType: attrs.ContentType,
+			md5:         attrs.MD5,
+			etag:        attrs.ETag,
+		}, nil
+	}
+	if gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, err
+	}
+
+	if modTime, ok := fs.Dirs.get(key); ok {
+		return &fileInfo{name: path.Base(key), dir: true, modTime: modTime}, nil
+	}
+	ok, err := fs.isDir(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &fileInfo{name: path.Base(key), dir: true}, nil
+}
+
+// readdir lists the entries directly under key.
+func (fs *FS) readdir(ctx context.Context, key string) ([]os.FileInfo, error) {
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+
+	seen := map[string]bool{}
+	infos := []os.FileInfo{}
+	iter := fs.Bucket.List(&blob.ListOptions{Prefix: prefix, Delimiter: "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		childKey := strings.TrimSuffix(obj.Key, "/")
+		if fs.hidden(childKey) {
+			continue
+		}
+		seen[childKey] = true
+		infos = append(infos, &fileInfo{
+			name:    path.Base(childKey),
+			size:    obj.Size,
+			modTime: obj.ModTime,
+			dir:     obj.IsDir,
+			md5:     obj.MD5,
+		})
+	}
+	for childKey, modTime := range fs.Dirs.children(key) {
+		if !seen[childKey] {
+			infos = append(infos, &fileInfo{name: path.Base(childKey), dir: true, modTime: modTime})
+		}
+	}
+	return infos, nil
+}
+
+// file is either an object opened for reading, an object being written or a
+// directory.
+type file struct {
+	fs   *FS
+	ctx  context.Context
+	key  string
+	info *fileInfo
+
+	// reading
+	reader *ReadSeeker
+	// listing
+	entries []os.FileInfo
+	listed  bool
+
+	// writing
+	writer   *blob.Writer
+	abort    context.CancelFunc
+	expected int64
+	written  int64
+}
+
+func (f *file) Close() error {
+	if f.reader != nil {
+		f.reader.Close()
+	}
+	if f.writer == nil {
+		return nil
+	}
+
+	defer f.abort()
+	if f.expected >= 0 && f.written != f.expected {
+		// Cancelling before Close aborts the write.
+		f.abort()
+		f.writer.Close()
+		return fmt.Errorf("received %d bytes of %d: %w", f.written, f.expected, io.ErrUnexpectedEOF)
+	}
+	return f.writer.Close()
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, os.ErrInvalid
+	}
+	return f.reader.Read(p)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.info.dir {
+		return 0, nil
+	}
+	if f.reader == nil {
+		return 0, os.ErrInvalid
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, os.ErrInvalid
+	}
+	if !f.listed {
+		entries, err := f.fs.readdir(f.ctx, f.key)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.listed = entries, true
+	}
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	if f.writer != nil {
+		info := *f.info
+		info.size = f.written
+		return &info, nil
+	}
+	return f.info, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.writer == nil {
+		return 0, os.ErrInvalid
+	}
+	n, err := f.writer.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+type fileInfo struct {
+	name        string
+	size        int64
+	modTime     time.Time
+	dir         bool
+	contentType string
+	md5         []byte
+	etag        string
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ETag implements webdav.ETager with the same ETag the DVC routes send.
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if len(fi.md5) > 0 {
+		return fmt.Sprintf("\"%s\"", base64.StdEncoding.EncodeToString(fi.md5)), nil
+	}
+	if fi.etag != "" {
+		return fi.etag, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+// ContentType implements webdav.ContentTyper.
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.contentType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.contentType, nil
+}
+
+var _ webdav.FileSystem = (*FS)(nil)
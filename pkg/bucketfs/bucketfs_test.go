@@ -0,0 +1,78 @@
+package bucketfs
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestRenameGoesThroughRemove(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	for _, key := range []string{"a/one", "a/b/two"} {
+		if err := bucket.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var removed []string
+	fs := &FS{
+		Bucket: bucket,
+		Remove: func(ctx context.Context, bucket *blob.Bucket, key string) error {
+			removed = append(removed, key)
+			return bucket.Delete(ctx, key)
+		},
+		Dirs: NewDirs(),
+	}
+
+	if err := fs.Rename(ctx, "/a/one", "/c/one"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename(ctx, "/a", "/d"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a/one", "a/b/two"}
+	if len(removed) != len(want) || removed[0] != want[0] || removed[1] != want[1] {
+		t.Errorf("removed %q, want %q", removed, want)
+	}
+	for key, exists := range map[string]bool{"a/one": false, "a/b/two": false, "c/one": true, "d/b/two": true} {
+		if got, _ := bucket.Exists(ctx, key); got != exists {
+			t.Errorf("%s exists %v, want %v", key, got, exists)
+		}
+	}
+}
+
+func TestHiddenFolders(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	for _, key := range []string{".trash/ab/cd/1650000000", ".multipart/0a/upload.json", "ab/cd"} {
+		if err := bucket.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := &FS{Bucket: bucket, Hidden: []string{".trash", ".multipart"}, Dirs: NewDirs()}
+
+	root, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	children, err := root.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 1 || children[0].Name() != "ab" {
+		t.Errorf("listed %d children, want ab only", len(children))
+	}
+	for _, name := range []string{"/.trash", "/.multipart/0a/upload.json"} {
+		if _, err := fs.Stat(ctx, name); !os.IsNotExist(err) {
+			t.Errorf("Stat(%s) returned %v, want not found", name, err)
+		}
+	}
+}
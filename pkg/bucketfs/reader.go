@@ -0,0 +1,93 @@
+package bucketfs
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"gocloud.dev/blob"
+)
+
+// ReadSeeker reads an object from any offset, as http.ServeContent and
+// WebDAV need. The object is read from the backend lazily, and read again
+// from the new offset after a Seek.
+type ReadSeeker struct {
+	ctx    context.Context
+	bucket *blob.Bucket
+	key    string
+	size   int64
+
+	reader *blob.Reader
+	offset int64
+	read   int64
+	err    error
+}
+
+// NewReadSeeker returns a ReadSeeker of the object key, of size bytes.
+func NewReadSeeker(ctx context.Context, bucket *blob.Bucket, key string, size int64) *ReadSeeker {
+	return &ReadSeeker{ctx: ctx, bucket: bucket, key: key, size: size}
+}
+
+func (rs *ReadSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.reader == nil {
+		reader, err := rs.bucket.NewRangeReader(rs.ctx, rs.key, rs.offset, -1, nil)
+		if err != nil {
+			rs.err = err
+			return 0, err
+		}
+		rs.reader = reader
+	}
+	n, err := rs.reader.Read(p)
+	rs.offset += int64(n)
+	rs.read += int64(n)
+	if err != nil && err != io.EOF {
+		rs.err = err
+	}
+	return n, err
+}
+
+// Seek moves the read offset, the object is read again from there on the
+// next Read.
+func (rs *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rs.offset
+	case io.SeekEnd:
+		offset += rs.size
+	default:
+		return 0, os.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	if offset != rs.offset && rs.reader != nil {
+		rs.reader.Close()
+		rs.reader = nil
+	}
+	rs.offset = offset
+	return offset, nil
+}
+
+func (rs *ReadSeeker) Close() error {
+	if rs.reader == nil {
+		return nil
+	}
+	err := rs.reader.Close()
+	rs.reader = nil
+	return err
+}
+
+// BytesRead returns the number of bytes read so far.
+func (rs *ReadSeeker) BytesRead() int64 {
+	return rs.read
+}
+
+// Err returns the last error met reading the backend, which
+// http.ServeContent does not report.
+func (rs *ReadSeeker) Err() error {
+	return rs.err
+}
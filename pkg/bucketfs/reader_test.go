@@ -0,0 +1,155 @@
+package bucketfs
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob/memblob"
+)
+
+const content = "0123456789abcdefghij"
+
+// serve answers a GET of the object with the Range and If-Range headers.
+func serve(t *testing.T, rangeHeader, ifRange string) *http.Response {
+	t.Helper()
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	t.Cleanup(func() { bucket.Close() })
+	if err := bucket.WriteAll(ctx, "ab/cd", []byte(content), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Date(2022, 4, 1, 10, 0, 0, 0, time.UTC)
+	r := httptest.NewRequest(http.MethodGet, "/ab/cd", nil)
+	if rangeHeader != "" {
+		r.Header.Set("Range", rangeHeader)
+	}
+	if ifRange != "" {
+		r.Header.Set("If-Range", ifRange)
+	}
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"etag"`)
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	rs := NewReadSeeker(ctx, bucket, "ab/cd", int64(len(content)))
+	defer rs.Close()
+	http.ServeContent(w, r, "", modTime, rs)
+	if err := rs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return w.Result()
+}
+
+func body(t *testing.T, response *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReadSeekerRanges(t *testing.T) {
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		ifRange      string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"whole", "", "", http.StatusOK, content, ""},
+		{"first bytes", "bytes=0-4", "", http.StatusPartialContent, "01234", "bytes 0-4/20"},
+		{"middle", "bytes=10-12", "", http.StatusPartialContent, "abc", "bytes 10-12/20"},
+		{"open ended", "bytes=15-", "", http.StatusPartialContent, "fghij", "bytes 15-19/20"},
+		{"suffix", "bytes=-3", "", http.StatusPartialContent, "hij", "bytes 17-19/20"},
+		{"suffix longer than object", "bytes=-50", "", http.StatusPartialContent, content, "bytes 0-19/20"},
+		{"end past the object", "bytes=18-100", "", http.StatusPartialContent, "ij", "bytes 18-19/20"},
+		{"start past the object", "bytes=20-", "", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{"matching If-Range", "bytes=0-1", `"etag"`, http.StatusPartialContent, "01", "bytes 0-1/20"},
+		{"stale If-Range", "bytes=0-1", `"other"`, http.StatusOK, content, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serve(t, test.rangeHeader, test.ifRange)
+			if response.StatusCode != test.status {
+				t.Fatalf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if got := response.Header.Get("Content-Range"); got != test.contentRange {
+				t.Errorf("Content-Range %q, want %q", got, test.contentRange)
+			}
+			if got := body(t, response); test.status != http.StatusRequestedRangeNotSatisfiable && got != test.body {
+				t.Errorf("body %q, want %q", got, test.body)
+			}
+		})
+	}
+}
+
+func TestReadSeekerMultipleRanges(t *testing.T) {
+	response := serve(t, "bytes=0-1, 5-6, -2", "")
+	if response.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status %d, want 206", response.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type %q, want multipart/byteranges", response.Header.Get("Content-Type"))
+	}
+
+	reader := multipart.NewReader(response.Body, params["boundary"])
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/20", "01"},
+		{"bytes 5-6/20", "56"},
+		{"bytes 18-19/20", "ij"},
+	}
+	for _, part := range want {
+		p, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Header.Get("Content-Range"); got != part.contentRange {
+			t.Errorf("Content-Range %q, want %q", got, part.contentRange)
+		}
+		b, _ := io.ReadAll(p)
+		if string(b) != part.body {
+			t.Errorf("part %q, want %q", b, part.body)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("more parts than ranges: %v", err)
+	}
+}
+
+func TestReadSeekerSeek(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	if err := bucket.WriteAll(ctx, "ab/cd", []byte(content), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewReadSeeker(ctx, bucket, "ab/cd", int64(len(content)))
+	defer rs.Close()
+	if offset, err := rs.Seek(-5, io.SeekEnd); err != nil || offset != 15 {
+		t.Fatalf("Seek = %d, %v", offset, err)
+	}
+	b := make([]byte, 3)
+	if _, err := io.ReadFull(rs, b); err != nil || string(b) != "fgh" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	if _, err := rs.Seek(-10, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(rs)
+	if err != nil || string(rest) != content[8:] {
+		t.Fatalf("read %q, %v", rest, err)
+	}
+	if _, err := rs.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seeking before the start succeeded")
+	}
+}
@@ -2,6 +2,7 @@ package dvc
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"strings"
@@ -10,6 +11,10 @@ import (
 	"gopkg.in/ini.v1"
 )
 
+// ErrHTTPRemote is returned by GetRemoteConfig for the http and https remotes,
+// which are usually this proxy itself.
+var ErrHTTPRemote = errors.New("HTTP remotes are not proxied")
+
 type DVCConfigParser struct {
 	content *ini.File
 }
@@ -18,7 +23,9 @@ func NewDVCConfig(content io.Reader, others ...interface{}) (*DVCConfigParser, e
 	config, err := ini.LoadSources(
 		ini.LoadOptions{
 			IgnoreInlineComment: true,
-			Insensitive:         true,
+			// Option names are case insensitive but remote names are not,
+			// core.remote refers to them as written.
+			InsensitiveKeys: true,
 		},
 		content,
 		others...,
@@ -41,6 +48,11 @@ func (config DVCConfigParser) ListRemotes() []string {
 	return remotes
 }
 
+// DefaultRemote returns the remote set in core.remote, if any.
+func (config DVCConfigParser) DefaultRemote() string {
+	return config.content.Section("core").Key("remote").String()
+}
+
 func (config DVCConfigParser) GetRemote(remote string) (*ini.Section, error) {
 	key := "'remote \"" + remote + "\"'"
 	return config.content.GetSection(key)
@@ -68,7 +80,89 @@ func GetRemoteType(URL string) (pool.ConfigType, error) {
 		return pool.ConfigTypeAzure, nil
 	case "http":
 		return pool.ConfigTypeHttp, nil
+	case "s3":
+		return pool.ConfigTypeS3, nil
+	case "gs":
+		return pool.ConfigTypeGCS, nil
 	default:
 		return pool.ConfigTypeUnknown, errors.New("Invalid Scheme")
 	}
 }
+
+// GetRemoteConfig builds the connection configuration of a remote from its
+// url and options.
+func (config DVCConfigParser) GetRemoteConfig(remote string) (*pool.ConnectionConfig, error) {
+	section, err := config.GetRemote(remote)
+	if err != nil {
+		return nil, err
+	}
+	urlValue, err := section.GetKey("url")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := url.Parse(urlValue.String())
+	if err != nil {
+		return nil, fmt.Errorf("url cannot be parsed, %w", err)
+	}
+	option := func(key string) string {
+		return section.Key(key).String()
+	}
+
+	var connectionConfig *pool.ConnectionConfig
+	switch parsed.Scheme {
+	case "azure":
+		connectionConfig, err = LoadAzureConfig(urlValue.String(), option("connection_string"))
+		if err != nil {
+			return nil, err
+		}
+		if accountName := option("account_name"); accountName != "" {
+			connectionConfig.AccountName = accountName
+		}
+		if accountKey := option("account_key"); accountKey != "" {
+			connectionConfig.AccountKey = accountKey
+		}
+	case "s3":
+		connectionConfig = &pool.ConnectionConfig{
+			Type:          pool.ConfigTypeS3,
+			URL:           parsed,
+			ContainerName: parsed.Host,
+			S3: pool.S3Config{
+				Endpoint: option("endpointurl"),
+				Region:   option("region"),
+				// Custom endpoints are S3 compatible servers, which seldom
+				// support virtual hosted buckets.
+				PathStyle:       option("endpointurl") != "",
+				AccessKeyID:     option("access_key_id"),
+				SecretAccessKey: option("secret_access_key"),
+				SessionToken:    option("session_token"),
+			},
+		}
+	case "gs":
+		connectionConfig = &pool.ConnectionConfig{
+			Type:          pool.ConfigTypeGCS,
+			URL:           parsed,
+			ContainerName: parsed.Host,
+			GCS: pool.GCSConfig{
+				CredentialsFile: option("credentialpath"),
+			},
+		}
+	case "http", "https":
+		return nil, fmt.Errorf("%w: %s", ErrHTTPRemote, parsed.Redacted())
+	case "", "file":
+		// Local remotes are plain paths
+		return &pool.ConnectionConfig{
+			Name:          remote,
+			Type:          pool.ConfigTypeHttp,
+			URL:           parsed,
+			ContainerName: parsed.Path,
+			RemoteId:      -1,
+		}, nil
+	default:
+		return nil, fmt.Errorf("remote %q of scheme %q cannot be proxied", remote, parsed.Scheme)
+	}
+
+	connectionConfig.Name = remote
+	connectionConfig.Prefix = strings.Trim(parsed.Path, "/")
+	connectionConfig.RemoteId = -1
+	return connectionConfig, nil
+}
@@ -0,0 +1,38 @@
+package dvc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ManifestEntry is a file of a directory tracked by DVC, as listed by the
+// .dir object of the directory.
+type ManifestEntry struct {
+	MD5     string `json:"md5"`
+	RelPath string `json:"relpath"`
+	// Size is only written by the versions of DVC recording it.
+	Size *int64 `json:"size,omitempty"`
+}
+
+// ParseManifest reads the JSON list of files of a .dir object.
+func ParseManifest(content io.Reader) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	if err := json.NewDecoder(content).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("manifest is not a JSON list of files, %w", err)
+	}
+	for i, entry := range entries {
+		sum, err := hex.DecodeString(entry.MD5)
+		if err != nil || len(sum) != 16 {
+			return nil, fmt.Errorf("entry #%d of manifest has an invalid md5 %q", i, entry.MD5)
+		}
+		clean := path.Clean(entry.RelPath)
+		if entry.RelPath == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("entry #%d of manifest has an invalid relpath %q", i, entry.RelPath)
+		}
+	}
+	return entries, nil
+}
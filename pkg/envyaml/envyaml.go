@@ -0,0 +1,53 @@
+// Package envyaml decodes the YAML configuration files, with the environment
+// variables they reference expanded.
+package envyaml
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Unmarshal decodes content into v once the $VAR and ${VAR} references of
+// its values are replaced by the environment. Only values are expanded, after
+// parsing, so the environment cannot change the structure of the document.
+// A literal $ is written $$.
+func Unmarshal(content []byte, v interface{}) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return err
+	}
+	if document.Kind == 0 {
+		// Empty document
+		return nil
+	}
+	expand(&document)
+	return document.Decode(v)
+}
+
+func expand(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		node.Value = Expand(node.Value)
+	case yaml.MappingNode:
+		// Keys are left as is, values are expanded
+		for i := 1; i < len(node.Content); i += 2 {
+			expand(node.Content[i])
+		}
+	default:
+		for _, child := range node.Content {
+			expand(child)
+		}
+	}
+}
+
+// Expand replaces the $VAR and ${VAR} references of s by the environment,
+// and $$ by $.
+func Expand(s string) string {
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		return os.Getenv(name)
+	})
+}
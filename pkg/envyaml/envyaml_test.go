@@ -0,0 +1,54 @@
+package envyaml
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	os.Setenv("ENVYAML_SECRET", "s3cr$t")
+	os.Setenv("ENVYAML_BREAKING", "a: b\nc: d")
+	defer os.Unsetenv("ENVYAML_SECRET")
+	defer os.Unsetenv("ENVYAML_BREAKING")
+
+	var v struct {
+		Secret  string            `yaml:"secret"`
+		Literal string            `yaml:"literal"`
+		Plain   string            `yaml:"plain"`
+		List    []string          `yaml:"list"`
+		Map     map[string]string `yaml:"map"`
+	}
+	content := []byte(`
+secret: ${ENVYAML_SECRET}
+literal: pa$$word$$
+plain: ${ENVYAML_BREAKING}
+list: [x-$ENVYAML_SECRET]
+map:
+  $ENVYAML_SECRET: value
+`)
+	if err := Unmarshal(content, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Secret != "s3cr$t" {
+		t.Errorf("secret = %q", v.Secret)
+	}
+	if v.Literal != "pa$word$" {
+		t.Errorf("literal = %q", v.Literal)
+	}
+	if v.Plain != "a: b\nc: d" {
+		t.Errorf("plain = %q, the environment changed the document", v.Plain)
+	}
+	if len(v.List) != 1 || v.List[0] != "x-s3cr$t" {
+		t.Errorf("list = %q", v.List)
+	}
+	if v.Map["$ENVYAML_SECRET"] != "value" {
+		t.Errorf("map = %v, keys must not be expanded", v.Map)
+	}
+}
+
+func TestUnmarshalEmpty(t *testing.T) {
+	var v struct{ A string }
+	if err := Unmarshal(nil, &v); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/dvc"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+)
+
+// archiveWriter adds the files of a dataset to an archive written as it goes.
+type archiveWriter interface {
+	add(name string, size int64, modTime time.Time, content io.Reader) error
+	Close() error
+}
+
+type tarArchive struct {
+	*tar.Writer
+}
+
+func (a tarArchive) add(name string, size int64, modTime time.Time, content io.Reader) error {
+	if err := a.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.Writer, content)
+	return err
+}
+
+type zipArchive struct {
+	*zip.Writer
+}
+
+func (a zipArchive) add(name string, size int64, modTime time.Time, content io.Reader) error {
+	w, err := a.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+// DownloadArchive streams the files listed by a .dir object as a tar, or a
+// zip with format=zip, each under its relpath. The archive is written as the
+// files are read from the remote, never buffered. A dataset with missing
+// files is refused before anything is sent.
+func (h Handler) DownloadArchive(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	params := parseVars(r)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "tar"
+	}
+	if format != "tar" && format != "zip" {
+		log.
+			WithField("format", format).
+			Warn("Unknown archive format")
+		http.Error(w, "Format must be tar or zip", http.StatusBadRequest)
+		return
+	}
+
+	conn, errGet := h.getConnection(params, auth.Read, w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
+		log.
+			WithError(errGet).
+			WithField("key", params.key).
+			Error("GetConnection Error")
+		return
+	}
+	defer conn.Close()
+
+	entries, errManifest := h.getManifest(params, conn, w, r)
+	if errManifest != nil {
+		// getManifest already wrote the error, stop the handler chain
+		return
+	}
+
+	refs := make([]objectRef, len(entries))
+	for i, entry := range entries {
+		refs[i] = objectRef{MD5: entry.MD5}
+	}
+	for _, status := range checkObjects(r.Context(), conn.Bucket, refs) {
+		if status.state != objectPresent {
+			// Write an error and stop the handler chain
+			log.
+				WithField("key", params.key).
+				WithField("file", status.ref.MD5).
+				WithError(status.err).
+				Warn("Dataset is incomplete")
+			http.Error(w, fmt.Sprintf("Dataset is incomplete, %s is not available", status.ref.MD5), http.StatusConflict)
+			return
+		}
+	}
+
+	var archive archiveWriter
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		archive = zipArchive{zip.NewWriter(w)}
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+		archive = tarArchive{tar.NewWriter(w)}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", params.checksum+"."+format))
+
+	for _, entry := range entries {
+		if errAdd := addToArchive(r, conn.Bucket, archive, entry); errAdd != nil {
+			// The archive is left without its trailer, so clients see it is
+			// truncated
+			log.
+				WithField("key", params.key).
+				WithField("relpath", entry.RelPath).
+				WithError(errAdd).
+				Error("Cannot write archive")
+			return
+		}
+	}
+	if errClose := archive.Close(); errClose != nil {
+		log.
+			WithField("key", params.key).
+			WithError(errClose).
+			Error("Cannot write archive")
+		return
+	}
+
+	log.
+		WithField("key", params.key).
+		WithField("format", format).
+		WithField("files", len(entries)).
+		Info("Archive download successful")
+}
+
+func addToArchive(r *http.Request, bucket *blob.Bucket, archive archiveWriter, entry dvc.ManifestEntry) error {
+	reader, err := bucket.NewReader(r.Context(), objectRef{MD5: entry.MD5}.key(), nil)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return archive.add(entry.RelPath, reader.Size(), reader.ModTime(), reader)
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/atekoa/dvc-http-remote/pkg/dvc"
+)
+
+func TestDownloadArchive(t *testing.T) {
+	server, connections, loader := testServer(t)
+	contents := map[string]string{
+		"train/a.csv":      "a,b\n1,2\n",
+		"train/deep/b.csv": "c,d\n3,4\n",
+		"README.md":        "# dataset\n",
+	}
+	var entries []dvc.ManifestEntry
+	for _, relpath := range []string{"train/a.csv", "train/deep/b.csv", "README.md"} {
+		entry := file(relpath, contents[relpath])
+		putObject(t, connections, loader[0], entry.MD5[:2]+"/"+entry.MD5[2:], contents[relpath])
+		entries = append(entries, entry)
+	}
+	key := putManifest(t, connections, loader[0], entries...)
+	checksum := strings.Replace(strings.TrimSuffix(key, ".dir"), "/", "", 1)
+
+	response, body := do(t, http.MethodGet, server.URL+"/remote/archive/"+key+"?remote=scratch", "", nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("tar status %d: %s", response.StatusCode, body)
+	}
+	if disposition := response.Header.Get("Content-Disposition"); disposition != `attachment; filename="`+checksum+`.tar"` {
+		t.Errorf("Content-Disposition %s", disposition)
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(strings.NewReader(body))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		got[header.Name] = string(content)
+	}
+	if len(got) != len(contents) {
+		t.Errorf("tar holds %q", got)
+	}
+	for name, content := range contents {
+		if got[name] != content {
+			t.Errorf("tar entry %s is %q, want %q", name, got[name], content)
+		}
+	}
+
+	response, body = do(t, http.MethodGet, server.URL+"/remote/archive/"+key+"?remote=scratch&format=zip", "", nil)
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("zip status %d, Content-Type %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader([]byte(body)), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(contents) {
+		t.Errorf("zip holds %d files", len(zr.File))
+	}
+	for _, f := range zr.File {
+		reader, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		if want, ok := contents[f.Name]; !ok || string(content) != want {
+			t.Errorf("zip entry %s is %q, want %q", f.Name, content, want)
+		}
+	}
+
+	response, _ = do(t, http.MethodGet, server.URL+"/remote/archive/"+key+"?remote=scratch&format=rar", "", nil)
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown format status %d, want 400", response.StatusCode)
+	}
+}
+
+func TestDownloadArchiveIncomplete(t *testing.T) {
+	server, connections, loader := testServer(t)
+	pushed := file("pushed.csv", "pushed")
+	putObject(t, connections, loader[0], pushed.MD5[:2]+"/"+pushed.MD5[2:], "pushed")
+	key := putManifest(t, connections, loader[0], pushed, file("lost.csv", "never pushed"))
+
+	for _, format := range []string{"tar", "zip"} {
+		response, body := do(t, http.MethodGet, server.URL+"/remote/archive/"+key+"?remote=scratch&format="+format, "", nil)
+		if response.StatusCode != http.StatusConflict {
+			t.Errorf("%s of an incomplete dataset: status %d, want 409", format, response.StatusCode)
+		}
+		if disposition := response.Header.Get("Content-Disposition"); disposition != "" || !strings.Contains(body, "incomplete") {
+			t.Errorf("%s of an incomplete dataset sent %q, %q", format, disposition, body)
+		}
+	}
+}
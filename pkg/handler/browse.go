@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/dvc"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/gcerrors"
+)
+
+var browseTemplate = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Manifest}}/{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+td.size { text-align: right; }
+code { color: #555; }
+</style>
+</head>
+<body>
+<h1>{{range $i, $crumb := .Breadcrumbs}}{{if $i}} / {{end}}<a href="{{$crumb.Href}}">{{$crumb.Name}}</a>{{end}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>MD5</th></tr>
+{{range .Dirs}}<tr><td><a href="{{.Href}}">{{.Name}}/</a></td><td class="size">{{.Files}} {{if eq .Files 1}}file{{else}}files{{end}}</td><td></td></tr>
+{{end}}{{range .Files}}<tr><td>{{if .Missing}}{{.Name}}{{else}}<a href="{{.Href}}">{{.Name}}</a>{{end}}</td><td class="size">{{if .Missing}}missing{{else}}{{.Size}}{{end}}</td><td><code>{{.MD5}}</code></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type browseResponse struct {
+	Manifest    string       `json:"manifest"`
+	Path        string       `json:"path"`
+	Dirs        []browseDir  `json:"dirs"`
+	Files       []browseFile `json:"files"`
+	Breadcrumbs []browseDir  `json:"-"`
+}
+
+type browseDir struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Href  string `json:"-"`
+}
+
+type browseFile struct {
+	Name    string `json:"name"`
+	RelPath string `json:"relpath"`
+	MD5     string `json:"md5"`
+	Size    int64  `json:"size"`
+	Missing bool   `json:"missing,omitempty"`
+	Href    string `json:"-"`
+}
+
+// BrowseManifest shows the files listed by a .dir object as a directory tree,
+// in HTML or in JSON with format=json. The files of the tree download under
+// their name in the manifest.
+func (h Handler) BrowseManifest(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	params := parseVars(r)
+	relpath := mux.Vars(r)["relpath"]
+
+	conn, errGet := h.getConnection(params, auth.Read, w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
+		log.
+			WithError(errGet).
+			WithField("key", params.key).
+			Error("GetConnection Error")
+		return
+	}
+	defer conn.Close()
+
+	entries, errManifest := h.getManifest(params, conn, w, r)
+	if errManifest != nil {
+		// getManifest already wrote the error, stop the handler chain
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.RelPath == relpath {
+			downloadManifestFile(w, r, conn, entry)
+			return
+		}
+	}
+
+	dir := strings.Trim(relpath, "/")
+	response, found := browseDirectory(r, conn, entries, dir)
+	if !found {
+		log.
+			WithField("key", params.key).
+			WithField("relpath", relpath).
+			Warn("Path is not in the manifest")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	response.Manifest = params.checksum + ".dir"
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, response)
+		return
+	}
+
+	root := strings.TrimSuffix(r.URL.Path, "/"+strings.TrimPrefix(relpath, "/"))
+	root = strings.TrimSuffix(root, "/")
+	response.link(root, r.URL.Query().Get("remote"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if errTemplate := browseTemplate.Execute(w, response); errTemplate != nil {
+		log.
+			WithField("key", params.key).
+			WithError(errTemplate).
+			Error("Cannot render directory")
+	}
+}
+
+// browseDirectory lists the subdirectories and the files directly under dir.
+// The sizes missing from the manifest are read from the remote.
+func browseDirectory(r *http.Request, conn *pool.CloudConn, entries []dvc.ManifestEntry, dir string) (browseResponse, bool) {
+	response := browseResponse{
+		Path:  dir,
+		Dirs:  []browseDir{},
+		Files: []browseFile{},
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	dirs := map[string]int{}
+	var refs []objectRef
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.RelPath, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(entry.RelPath, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			dirs[name[:i]]++
+			continue
+		}
+		response.Files = append(response.Files, browseFile{Name: name, RelPath: entry.RelPath, MD5: entry.MD5})
+		refs = append(refs, objectRef{MD5: entry.MD5, Size: entry.Size})
+	}
+	if len(dirs) == 0 && len(response.Files) == 0 && dir != "" {
+		return response, false
+	}
+
+	for name, files := range dirs {
+		response.Dirs = append(response.Dirs, browseDir{Name: name, Files: files})
+	}
+	sort.Slice(response.Dirs, func(i, j int) bool { return response.Dirs[i].Name < response.Dirs[j].Name })
+
+	for i, status := range checkObjects(r.Context(), conn.Bucket, refs) {
+		switch {
+		case status.state == objectMissing:
+			response.Files[i].Missing = true
+		case status.ref.Size != nil:
+			response.Files[i].Size = *status.ref.Size
+		default:
+			response.Files[i].Size = status.size
+		}
+	}
+	sort.Slice(response.Files, func(i, j int) bool { return response.Files[i].Name < response.Files[j].Name })
+	return response, true
+}
+
+// link sets the links of the HTML view, relative to the URL browsing the
+// root of the manifest.
+func (response *browseResponse) link(root, remote string) {
+	query := ""
+	if remote != "" {
+		query = "?remote=" + url.QueryEscape(remote)
+	}
+	href := func(relpath string) string {
+		segments := strings.Split(relpath, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return root + "/" + strings.Join(segments, "/") + query
+	}
+
+	response.Breadcrumbs = []browseDir{{Name: response.Manifest, Href: root + "/" + query}}
+	if response.Path != "" {
+		segments := strings.Split(response.Path, "/")
+		for i, segment := range segments {
+			response.Breadcrumbs = append(response.Breadcrumbs, browseDir{
+				Name: segment,
+				Href: href(strings.Join(segments[:i+1], "/")),
+			})
+		}
+	}
+	for i, dir := range response.Dirs {
+		response.Dirs[i].Href = href(path.Join(response.Path, dir.Name))
+	}
+	for i, file := range response.Files {
+		response.Files[i].Href = href(file.RelPath)
+	}
+}
+
+// downloadManifestFile sends the object of a manifest entry as an attachment
+// named after its relpath.
+func downloadManifestFile(w http.ResponseWriter, r *http.Request, conn *pool.CloudConn, entry dvc.ManifestEntry) {
+	key := objectRef{MD5: entry.MD5}.key()
+	reader, errReader := conn.NewReader(r.Context(), key, nil)
+	if gcerrors.Code(errReader) == gcerrors.NotFound {
+		// Write an error and stop the handler chain
+		log.
+			WithField("key", key).
+			WithField("relpath", entry.RelPath).
+			Warn("Blob does not exists")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if errReader != nil {
+		// Write an error and stop the handler chain
+		log.
+			WithField("key", key).
+			WithError(errReader).
+			Error("Error creating Bucket Reader")
+		http.Error(w, "Error creating Bucket Reader", http.StatusBadGateway)
+		return
+	}
+	defer reader.Close()
+
+	name := path.Base(entry.RelPath)
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = reader.ContentType()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(reader.Size(), 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	// The type comes from a name chosen by whoever pushed the dataset,
+	// browsers must not guess another one
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, errCopy := reader.WriteTo(w); errCopy != nil {
+		log.
+			WithField("key", key).
+			WithField("relpath", entry.RelPath).
+			WithError(errCopy).
+			Error("Error sending file")
+		return
+	}
+	log.
+		WithField("key", key).
+		WithField("relpath", entry.RelPath).
+		Info("Download successful")
+}
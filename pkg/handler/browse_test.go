@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBrowseManifest(t *testing.T) {
+	server, connections, loader := testServer(t)
+	readme := file("README.md", "# dataset\n")
+	a := file("train/a.csv", "a,b\n1,2\n")
+	b := file("train/deep/b.json", `{"c": 3}`)
+	lost := file("train/lost.csv", "never pushed")
+	for _, entry := range []struct {
+		md5, content string
+	}{
+		{readme.MD5, "# dataset\n"},
+		{a.MD5, "a,b\n1,2\n"},
+		{b.MD5, `{"c": 3}`},
+	} {
+		putObject(t, connections, loader[0], entry.md5[:2]+"/"+entry.md5[2:], entry.content)
+	}
+	key := putManifest(t, connections, loader[0], readme, a, b, lost)
+	root := server.URL + "/remote/browse/" + key
+
+	response, body := do(t, http.MethodGet, root+"/train?remote=scratch&format=json", "", nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("JSON listing status %d: %s", response.StatusCode, body)
+	}
+	var got browseResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := browseResponse{
+		Manifest: strings.Replace(key, "/", "", 1),
+		Path:     "train",
+		Dirs:     []browseDir{{Name: "deep", Files: 1}},
+		Files: []browseFile{
+			{Name: "a.csv", RelPath: "train/a.csv", MD5: a.MD5, Size: *a.Size},
+			{Name: "lost.csv", RelPath: "train/lost.csv", MD5: lost.MD5, Missing: true},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON listing %+v, want %+v", got, want)
+	}
+
+	response, body = do(t, http.MethodGet, root+"/?remote=scratch", "", nil)
+	if response.StatusCode != http.StatusOK || !strings.HasPrefix(response.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("HTML listing status %d, Content-Type %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	for _, link := range []string{
+		`href="/remote/browse/` + key + `/train?remote=scratch"`,
+		`href="/remote/browse/` + key + `/README.md?remote=scratch"`,
+	} {
+		if !strings.Contains(body, link) {
+			t.Errorf("HTML listing has no %s:\n%s", link, body)
+		}
+	}
+
+	response, body = do(t, http.MethodGet, root+"/train/deep/b.json?remote=scratch", "", nil)
+	if response.StatusCode != http.StatusOK || body != `{"c": 3}` {
+		t.Fatalf("download status %d, %q", response.StatusCode, body)
+	}
+	for name, want := range map[string]string{
+		"Content-Disposition":    `attachment; filename=b.json`,
+		"Content-Type":           "application/json",
+		"X-Content-Type-Options": "nosniff",
+	} {
+		if got := response.Header.Get(name); got != want {
+			t.Errorf("download %s %q, want %q", name, got, want)
+		}
+	}
+
+	for _, relpath := range []string{"/train/lost.csv", "/nowhere", "/train/a.csv/more"} {
+		if response, _ := do(t, http.MethodGet, root+relpath+"?remote=scratch", "", nil); response.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", relpath, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/dvc"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// errNotManifest is returned for keys that are not .dir objects.
+var errNotManifest = errors.New("not a .dir object")
+
+// getManifest reads the .dir object of the request and parses its list of
+// files. Like getConnection, it writes the error response itself.
+func (h *Handler) getManifest(params params, conn *pool.CloudConn, w http.ResponseWriter, r *http.Request) ([]dvc.ManifestEntry, error) {
+	if !strings.HasSuffix(params.key, ".dir") {
+		// Write an error and stop the handler chain
+		log.
+			WithField("key", params.key).
+			Warn("Not a .dir object")
+		http.Error(w, "Not a .dir object", http.StatusBadRequest)
+		return nil, errNotManifest
+	}
+
+	reader, errReader := conn.NewReader(r.Context(), params.key, nil)
+	if gcerrors.Code(errReader) == gcerrors.NotFound {
+		// Write an error and stop the handler chain
+		log.
+			WithField("key", params.key).
+			Warn("Blob does not exists")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil, errReader
+	}
+	if errReader != nil {
+		// Write an error and stop the handler chain
+		log.
+			WithField("key", params.key).
+			WithError(errReader).
+			Error("Error creating Bucket Reader")
+		http.Error(w, "Error creating Bucket Reader", http.StatusBadGateway)
+		return nil, errReader
+	}
+	defer reader.Close()
+
+	entries, errParse := dvc.ParseManifest(reader)
+	if errParse != nil {
+		// Write an error and stop the handler chain
+		log.
+			WithField("key", params.key).
+			WithError(errParse).
+			Error("Cannot parse manifest")
+		http.Error(w, "Cannot parse manifest", http.StatusUnprocessableEntity)
+		return nil, errParse
+	}
+	return entries, nil
+}
+
+type manifestFile struct {
+	MD5     string `json:"md5"`
+	RelPath string `json:"relpath"`
+}
+
+type corruptFile struct {
+	manifestFile
+	Reason string `json:"reason"`
+}
+
+type fileError struct {
+	manifestFile
+	Error string `json:"error"`
+}
+
+type checkResponse struct {
+	Manifest string         `json:"manifest"`
+	Files    int            `json:"files"`
+	Present  int            `json:"present"`
+	Complete bool           `json:"complete"`
+	Missing  []manifestFile `json:"missing"`
+	Corrupt  []corruptFile  `json:"corrupt"`
+	Errors   []fileError    `json:"errors"`
+}
+
+// CheckManifest reads a .dir object and reports which of the files it lists
+// are missing from the remote or corrupt, which is how a partially pushed
+// dataset shows. A file is corrupt when its size or the checksum the backend
+// holds differ from the manifest. With deep=true the content of every file is
+// also read and hashed.
+func (h Handler) CheckManifest(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	params := parseVars(r)
+	deep, _ := strconv.ParseBool(r.URL.Query().Get("deep"))
+
+	conn, errGet := h.getConnection(params, auth.Read, w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
+		log.
+			WithError(errGet).
+			WithField("key", params.key).
+			Error("GetConnection Error")
+		return
+	}
+	defer conn.Close()
+
+	entries, errManifest := h.getManifest(params, conn, w, r)
+	if errManifest != nil {
+		// getManifest already wrote the error, stop the handler chain
+		return
+	}
+
+	refs := make([]objectRef, len(entries))
+	for i, entry := range entries {
+		refs[i] = objectRef{MD5: entry.MD5, Size: entry.Size}
+	}
+	statuses := checkObjects(r.Context(), conn.Bucket, refs)
+
+	reasons := make([]string, len(statuses))
+	parallel(len(statuses), func(i int) {
+		reasons[i] = corruption(r.Context(), conn.Bucket, statuses[i], deep)
+	})
+
+	response := checkResponse{
+		Manifest: params.checksum + ".dir",
+		Files:    len(entries),
+		Missing:  []manifestFile{},
+		Corrupt:  []corruptFile{},
+		Errors:   []fileError{},
+	}
+	for i, status := range statuses {
+		file := manifestFile{entries[i].MD5, entries[i].RelPath}
+		switch {
+		case status.state == objectMissing:
+			response.Missing = append(response.Missing, file)
+		case status.state == objectError:
+			response.Errors = append(response.Errors, fileError{file, status.err.Error()})
+		case reasons[i] != "":
+			response.Corrupt = append(response.Corrupt, corruptFile{file, reasons[i]})
+		default:
+			response.Present++
+		}
+	}
+	response.Complete = response.Present == response.Files
+
+	log.
+		WithField("key", params.key).
+		WithField("files", response.Files).
+		WithField("missing", len(response.Missing)).
+		WithField("corrupt", len(response.Corrupt)).
+		Info("Checked manifest")
+	writeJSON(w, response)
+}
+
+// corruption tells why a found object does not match its manifest entry, or
+// returns an empty string when it does.
+func corruption(ctx context.Context, bucket *blob.Bucket, status objectStatus, deep bool) string {
+	switch {
+	case status.state == objectMismatched:
+		return fmt.Sprintf("size is %d, expected %d", status.size, *status.ref.Size)
+	case status.state != objectPresent:
+		return ""
+	case len(status.md5) > 0 && hex.EncodeToString(status.md5) != status.ref.MD5:
+		return fmt.Sprintf("checksum is %x", status.md5)
+	case !deep:
+		return ""
+	}
+
+	reader, err := bucket.NewReader(ctx, status.ref.key(), nil)
+	if err != nil {
+		return fmt.Sprintf("cannot be read, %v", err)
+	}
+	defer reader.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return fmt.Sprintf("cannot be read, %v", err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != status.ref.MD5 {
+		return fmt.Sprintf("content hashes to %s", sum)
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckManifest(t *testing.T) {
+	server, connections, loader := testServer(t)
+	scratch := loader[0]
+	present := file("data/present.csv", "a,b\n1,2\n")
+	missing := file("data/missing.csv", "never pushed")
+	resized := file("data/resized.csv", "0123456789")
+	altered := file("data/altered.csv", "good")
+	for _, entry := range []struct {
+		md5, content string
+	}{
+		{present.MD5, "a,b\n1,2\n"},
+		{resized.MD5, "01234"},
+		{altered.MD5, "bad!"},
+	} {
+		putObject(t, connections, scratch, entry.md5[:2]+"/"+entry.md5[2:], entry.content)
+	}
+	key := putManifest(t, connections, scratch, present, missing, resized, altered)
+
+	response, body := do(t, http.MethodGet, server.URL+"/remote/check/"+key+"?remote=scratch", "", nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, body)
+	}
+	var got checkResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Manifest != strings.Replace(key, "/", "", 1) || got.Files != 4 || got.Present != 1 || got.Complete {
+		t.Errorf("check %+v", got)
+	}
+	if len(got.Missing) != 1 || got.Missing[0].RelPath != missing.RelPath {
+		t.Errorf("missing %+v, want %s", got.Missing, missing.RelPath)
+	}
+	reasons := map[string]string{}
+	for _, corrupt := range got.Corrupt {
+		reasons[corrupt.RelPath] = corrupt.Reason
+	}
+	if len(reasons) != 2 || reasons[resized.RelPath] != "size is 5, expected 10" || !strings.HasPrefix(reasons[altered.RelPath], "checksum is ") {
+		t.Errorf("corrupt %+v", got.Corrupt)
+	}
+
+	// A dataset whose files are all there is complete
+	key = putManifest(t, connections, scratch, present)
+	response, body = do(t, http.MethodGet, server.URL+"/remote/check/"+key+"?remote=scratch&deep=true", "", nil)
+	got = checkResponse{}
+	if err := json.Unmarshal([]byte(body), &got); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, body)
+	}
+	if !got.Complete || got.Files != 1 || got.Present != 1 || len(got.Missing)+len(got.Corrupt)+len(got.Errors) != 0 {
+		t.Errorf("complete dataset %+v", got)
+	}
+}
+
+func TestCheckManifestInvalid(t *testing.T) {
+	server, connections, loader := testServer(t)
+	plain, _ := object("plain object")
+	putObject(t, connections, loader[0], plain, "plain object")
+	absent, _ := object("absent manifest")
+	putObject(t, connections, loader[0], "ab/cdef.dir", "not json")
+
+	tests := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"not a manifest", plain, http.StatusBadRequest},
+		{"missing manifest", absent + ".dir", http.StatusNotFound},
+		{"malformed manifest", "ab/cdef.dir", http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		response, _ := do(t, http.MethodGet, server.URL+"/remote/check/"+test.key+"?remote=scratch", "", nil)
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"github.com/klauspost/compress/zstd"
+	"gocloud.dev/blob"
+)
+
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+// supportedEncodings is ordered by preference, used to break ties between
+// codings the client accepts with the same quality.
+var supportedEncodings = []string{encodingZstd, encodingGzip}
+
+// negotiateEncoding picks the content coding used to send the object key, or
+// "" when it must be sent as is.
+func negotiateEncoding(acceptEncoding string, policy pool.CompressionPolicy, key string, attrs *blob.Attributes) string {
+	if !policy.Enabled || attrs.Size < policy.MinSize || attrs.ContentEncoding != "" {
+		return ""
+	}
+	if !strings.HasSuffix(key, ".dir") &&
+		(!matchContentType(attrs.ContentType, policy.ContentTypes) || matchContentType(attrs.ContentType, policy.SkipTypes)) {
+		return ""
+	}
+
+	accepted := parseAcceptEncoding(acceptEncoding)
+	best, bestQ := "", 0.0
+	for _, encoding := range supportedEncodings {
+		q, ok := accepted[encoding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// conditional reports whether r carries a range or a precondition. Those are
+// answered by ServeContent against the identity representation, so such a
+// request is never compressed.
+func conditional(r *http.Request) bool {
+	for _, name := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAcceptEncoding maps each coding of an Accept-Encoding header to its
+// quality value.
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params := part, ""
+		if i := strings.Index(part, ";"); i >= 0 {
+			coding, params = part[:i], part[i+1:]
+		}
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				value, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					value = 0
+				}
+				q = value
+			}
+		}
+		if coding == "x-gzip" {
+			coding = encodingGzip
+		}
+		accepted[coding] = q
+	}
+	return accepted
+}
+
+// matchContentType reports whether contentType is one of types.
+func matchContentType(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+	for _, s := range types {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "*" || s == "*/*" {
+			return true
+		}
+		s = strings.TrimSuffix(s, "*")
+		if s == "" {
+			continue
+		}
+		if mediaType == s || (strings.HasSuffix(s, "/") && strings.HasPrefix(mediaType, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// newEncoder wraps w so what is written to it is compressed with encoding.
+func newEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case encodingZstd:
+		// One goroutine per response, instead of one per CPU by default
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return gzip.NewWriter(w), nil
+	}
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"gocloud.dev/blob"
+)
+
+func TestParseAcceptEncoding(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		want   map[string]float64
+	}{
+		{"", map[string]float64{}},
+		{"gzip", map[string]float64{"gzip": 1}},
+		{"gzip, zstd", map[string]float64{"gzip": 1, "zstd": 1}},
+		{"GZIP;q=0.5, zstd;q=0", map[string]float64{"gzip": 0.5, "zstd": 0}},
+		{"x-gzip", map[string]float64{"gzip": 1}},
+		{"*;q=0.1, identity", map[string]float64{"*": 0.1, "identity": 1}},
+		{"br;q=bogus", map[string]float64{"br": 0}},
+		{" , gzip ; level=9 ; q=0.8", map[string]float64{"gzip": 0.8}},
+	} {
+		if got := parseAcceptEncoding(test.header); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseAcceptEncoding(%q) = %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	policy := pool.CompressionPolicy{
+		Enabled:      true,
+		MinSize:      100,
+		ContentTypes: []string{"text/", "application/json"},
+		SkipTypes:    []string{"text/html"},
+	}
+	everything := policy
+	everything.ContentTypes = []string{"*"}
+	everything.SkipTypes = []string{"image/", "application/zip"}
+	disabled := policy
+	disabled.Enabled = false
+
+	csv := &blob.Attributes{Size: 1000, ContentType: "text/csv; charset=utf-8"}
+	for _, test := range []struct {
+		name           string
+		acceptEncoding string
+		policy         pool.CompressionPolicy
+		key            string
+		attrs          *blob.Attributes
+		want           string
+	}{
+		{"zstd preferred on a tie", "gzip, zstd", policy, "ab/cd", csv, encodingZstd},
+		{"higher quality wins", "gzip;q=1, zstd;q=0.5", policy, "ab/cd", csv, encodingGzip},
+		{"q=0 refuses a coding", "zstd;q=0, gzip", policy, "ab/cd", csv, encodingGzip},
+		{"q=0 everywhere", "zstd;q=0, gzip;q=0", policy, "ab/cd", csv, ""},
+		{"wildcard", "*", policy, "ab/cd", csv, encodingZstd},
+		{"wildcard refused", "*;q=0", policy, "ab/cd", csv, ""},
+		{"explicit coding beats wildcard", "*;q=0, gzip", policy, "ab/cd", csv, encodingGzip},
+		{"no Accept-Encoding", "", policy, "ab/cd", csv, ""},
+		{"unsupported coding", "br", policy, "ab/cd", csv, ""},
+		{"disabled", "gzip", disabled, "ab/cd", csv, ""},
+		{"too small", "gzip", policy, "ab/cd", &blob.Attributes{Size: 99, ContentType: "text/csv"}, ""},
+		{"binary", "gzip", policy, "ab/cd", &blob.Attributes{Size: 1000, ContentType: "application/octet-stream"}, ""},
+		{"skipped type", "gzip", policy, "ab/cd", &blob.Attributes{Size: 1000, ContentType: "text/html"}, ""},
+		{"already encoded", "gzip", policy, "ab/cd", &blob.Attributes{Size: 1000, ContentType: "text/csv", ContentEncoding: "gzip"}, ""},
+		{"manifest of any type", "gzip", policy, "ab/cd.dir", &blob.Attributes{Size: 1000, ContentType: "application/octet-stream"}, encodingGzip},
+		{"everything", "gzip", everything, "ab/cd", &blob.Attributes{Size: 1000, ContentType: "application/octet-stream"}, encodingGzip},
+		{"everything but images", "gzip", everything, "ab/cd", &blob.Attributes{Size: 1000, ContentType: "image/png"}, ""},
+		{"everything but archives", "gzip", everything, "ab/cd", &blob.Attributes{Size: 1000, ContentType: "application/zip"}, ""},
+	} {
+		if got := negotiateEncoding(test.acceptEncoding, test.policy, test.key, test.attrs); got != test.want {
+			t.Errorf("%s: negotiateEncoding(%q) = %q, want %q", test.name, test.acceptEncoding, got, test.want)
+		}
+	}
+}
+
+func TestDownloadCompressed(t *testing.T) {
+	server, _, loader := testServer(t)
+	loader[0].Compression = pool.CompressionPolicy{Enabled: true, ContentTypes: []string{"*"}}
+	content := strings.Repeat("path,md5\n", 200)
+	key, contentMD5 := object(content)
+	url := server.URL + "/remote/" + key + "?remote=scratch"
+
+	response, _ := do(t, http.MethodPut, url, content, http.Header{"Content-Md5": {contentMD5}})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("upload status %d", response.StatusCode)
+	}
+
+	// Setting Accept-Encoding stops the client from decoding the body
+	response, body := do(t, http.MethodGet, url, "", http.Header{"Accept-Encoding": {"gzip"}})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("GET status %d", response.StatusCode)
+	}
+	if encoding := response.Header.Get("Content-Encoding"); encoding != encodingGzip {
+		t.Fatalf("Content-Encoding %q, want gzip", encoding)
+	}
+	if vary := response.Header.Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Vary %q, want Accept-Encoding", vary)
+	}
+	// net/http may still count a short compressed body, but the length of
+	// the object must not leak into the compressed response
+	if response.ContentLength == int64(len(content)) {
+		t.Errorf("compressed response has the Content-Length of the object")
+	}
+	if etag := response.Header.Get("ETag"); etag != `"`+contentMD5+`-gzip"` {
+		t.Errorf("ETag %s, want the MD5 of the content and the coding", etag)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil || string(decoded) != content {
+		t.Errorf("decoded %d bytes (%v), want the content", len(decoded), err)
+	}
+
+	// Ranges and preconditions are answered on the identity representation
+	for _, header := range []http.Header{
+		{"Range": {"bytes=0-8"}},
+		{"If-None-Match": {`"` + contentMD5 + `"`}},
+	} {
+		header.Set("Accept-Encoding", "gzip")
+		response, _ := do(t, http.MethodGet, url, "", header)
+		if encoding := response.Header.Get("Content-Encoding"); encoding != "" {
+			t.Errorf("%v: Content-Encoding %q, want none", header, encoding)
+		}
+		if vary := response.Header.Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("%v: Vary %q, want Accept-Encoding", header, vary)
+		}
+	}
+	response, _ = do(t, http.MethodGet, url, "", http.Header{
+		"Accept-Encoding": {"gzip"},
+		"If-None-Match":   {`"` + contentMD5 + `"`},
+	})
+	if response.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match status %d, want 304", response.StatusCode)
+	}
+}
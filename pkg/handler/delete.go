@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/trash"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/gcerrors"
+)
+
+// DeleteFile moves the object to the trash of its remote, from where it can
+// be restored until the retention of the remote is over.
+func (h Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	params := parseVars(r)
+
+	conn, errGet := h.getConnection(params, auth.Write, w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
+		log.
+			WithError(errGet).
+			WithField("key", params.key).
+			Error("GetConnection Error")
+		return
+	}
+	defer conn.Close()
+
+	if !conn.Config().AllowDelete {
+		log.
+			WithField("remote", params.remote).
+			WithField("key", params.key).
+			Warn("Delete is not allowed on this remote")
+		http.Error(w, "Delete is not allowed on this remote", http.StatusForbidden)
+		return
+	}
+
+	errMove := trash.Move(r.Context(), conn.Bucket, conn.Config().Trash, params.key)
+	if gcerrors.Code(errMove) == gcerrors.NotFound {
+		log.
+			WithField("key", params.key).
+			Warn("Blob does not exists")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if errMove != nil {
+		log.
+			WithField("Code", gcerrors.Code(errMove)).
+			WithField("key", params.key).
+			WithError(errMove).
+			Error("Cannot move to trash")
+		http.Error(w, "Cannot move to trash", http.StatusBadGateway)
+		return
+	}
+
+	log.
+		WithField("key", params.key).
+		Info("Moved to trash")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreFile puts back the last deleted copy of an object.
+func (h Handler) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	params := parseVars(r)
+
+	conn, errGet := h.getConnection(params, auth.Write, w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
+		log.
+			WithError(errGet).
+			WithField("key", params.key).
+			Error("GetConnection Error")
+		return
+	}
+	defer conn.Close()
+
+	if !conn.Config().AllowDelete {
+		log.
+			WithField("remote", params.remote).
+			WithField("key", params.key).
+			Warn("Delete is not allowed on this remote")
+		http.Error(w, "Delete is not allowed on this remote", http.StatusForbidden)
+		return
+	}
+
+	errRestore := trash.Restore(r.Context(), conn.Bucket, conn.Config().Trash, params.key)
+	if errors.Is(errRestore, trash.ErrNotInTrash) {
+		log.
+			WithField("key", params.key).
+			Warn("Blob is not in the trash")
+		http.Error(w, "Blob is not in the trash", http.StatusNotFound)
+		return
+	}
+	if errRestore != nil {
+		log.
+			WithField("Code", gcerrors.Code(errRestore)).
+			WithField("key", params.key).
+			WithError(errRestore).
+			Error("Cannot restore from trash")
+		http.Error(w, "Cannot restore from trash", http.StatusBadGateway)
+		return
+	}
+
+	log.
+		WithField("key", params.key).
+		Info("Restored from trash")
+}
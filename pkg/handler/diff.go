@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/dvc"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	log "github.com/sirupsen/logrus"
+)
+
+type diffFile struct {
+	RelPath string `json:"relpath"`
+	MD5     string `json:"md5"`
+	Size    *int64 `json:"size"`
+}
+
+type modifiedFile struct {
+	RelPath string `json:"relpath"`
+	OldMD5  string `json:"old_md5"`
+	NewMD5  string `json:"new_md5"`
+	OldSize *int64 `json:"old_size"`
+	NewSize *int64 `json:"new_size"`
+}
+
+type diffResponse struct {
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	Added     []diffFile     `json:"added"`
+	Removed   []diffFile     `json:"removed"`
+	Modified  []modifiedFile `json:"modified"`
+	Unchanged int            `json:"unchanged"`
+	// Delta is the change of the size of the dataset in bytes. Files whose
+	// size is in neither the manifest nor the remote do not count, in which
+	// case SizesKnown is false.
+	Delta      int64 `json:"delta"`
+	SizesKnown bool  `json:"sizes_known"`
+}
+
+// DiffManifests compares the .dir objects given by the from and to query
+// parameters and returns the files added, removed and modified between both
+// versions of the dataset.
+func (h Handler) DiffManifests(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	base := parseVars(r)
+
+	var manifests [2]params
+	for i, name := range []string{"from", "to"} {
+		ref := objectRef{MD5: strings.TrimSuffix(r.URL.Query().Get(name), ".dir") + ".dir"}
+		if errValid := ref.validate(); errValid != nil {
+			// Write an error and stop the handler chain
+			log.
+				WithField(name, ref.MD5).
+				WithError(errValid).
+				Warn("Invalid manifest hash")
+			http.Error(w, "from and to must be .dir hashes", http.StatusBadRequest)
+			return
+		}
+		manifests[i] = base
+		manifests[i].key = ref.key()
+		manifests[i].checksum = strings.TrimSuffix(ref.MD5, ".dir")
+	}
+
+	conn, errGet := h.getConnection(base, auth.Read, w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
+		log.
+			WithError(errGet).
+			Error("GetConnection Error")
+		return
+	}
+	defer conn.Close()
+
+	from, errManifest := h.getManifest(manifests[0], conn, w, r)
+	if errManifest != nil {
+		// getManifest already wrote the error, stop the handler chain
+		return
+	}
+	to, errManifest := h.getManifest(manifests[1], conn, w, r)
+	if errManifest != nil {
+		// getManifest already wrote the error, stop the handler chain
+		return
+	}
+
+	response := diff(from, to)
+	response.From = manifests[0].checksum + ".dir"
+	response.To = manifests[1].checksum + ".dir"
+	fillSizes(r, conn, &response)
+
+	log.
+		WithField("from", response.From).
+		WithField("to", response.To).
+		WithField("added", len(response.Added)).
+		WithField("removed", len(response.Removed)).
+		WithField("modified", len(response.Modified)).
+		Info("Compared manifests")
+	writeJSON(w, response)
+}
+
+// diff compares two manifests by relpath. Sizes are those of the manifests,
+// when they hold them.
+func diff(from, to []dvc.ManifestEntry) diffResponse {
+	response := diffResponse{
+		Added:    []diffFile{},
+		Removed:  []diffFile{},
+		Modified: []modifiedFile{},
+	}
+
+	old := make(map[string]dvc.ManifestEntry, len(from))
+	for _, entry := range from {
+		old[entry.RelPath] = entry
+	}
+	for _, entry := range to {
+		previous, ok := old[entry.RelPath]
+		delete(old, entry.RelPath)
+		switch {
+		case !ok:
+			response.Added = append(response.Added, diffFile{entry.RelPath, entry.MD5, entry.Size})
+		case previous.MD5 != entry.MD5:
+			response.Modified = append(response.Modified, modifiedFile{entry.RelPath, previous.MD5, entry.MD5, previous.Size, entry.Size})
+		default:
+			response.Unchanged++
+		}
+	}
+	for _, entry := range old {
+		response.Removed = append(response.Removed, diffFile{entry.RelPath, entry.MD5, entry.Size})
+	}
+
+	sort.Slice(response.Added, func(i, j int) bool { return response.Added[i].RelPath < response.Added[j].RelPath })
+	sort.Slice(response.Removed, func(i, j int) bool { return response.Removed[i].RelPath < response.Removed[j].RelPath })
+	sort.Slice(response.Modified, func(i, j int) bool { return response.Modified[i].RelPath < response.Modified[j].RelPath })
+	return response
+}
+
+// fillSizes reads from the remote the sizes of the changed files that the
+// manifests do not hold, then computes the delta.
+func fillSizes(r *http.Request, conn *pool.CloudConn, response *diffResponse) {
+	var sizes []**int64
+	var refs []objectRef
+	need := func(md5 string, size **int64) {
+		if *size == nil {
+			sizes = append(sizes, size)
+			refs = append(refs, objectRef{MD5: md5})
+		}
+	}
+	for i := range response.Added {
+		need(response.Added[i].MD5, &response.Added[i].Size)
+	}
+	for i := range response.Removed {
+		need(response.Removed[i].MD5, &response.Removed[i].Size)
+	}
+	for i := range response.Modified {
+		need(response.Modified[i].OldMD5, &response.Modified[i].OldSize)
+		need(response.Modified[i].NewMD5, &response.Modified[i].NewSize)
+	}
+
+	for i, status := range checkObjects(r.Context(), conn.Bucket, refs) {
+		if status.state == objectPresent {
+			size := status.size
+			*sizes[i] = &size
+		}
+	}
+
+	response.SizesKnown = true
+	add := func(size *int64, sign int64) {
+		if size == nil {
+			response.SizesKnown = false
+			return
+		}
+		response.Delta += sign * *size
+	}
+	for _, file := range response.Added {
+		add(file.Size, 1)
+	}
+	for _, file := range response.Removed {
+		add(file.Size, -1)
+	}
+	for _, file := range response.Modified {
+		add(file.NewSize, 1)
+		add(file.OldSize, -1)
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffManifests(t *testing.T) {
+	server, connections, loader := testServer(t)
+	scratch := loader[0]
+	kept := file("kept.csv", "kept")
+	removed := file("removed.csv", "gone!!")
+	before := file("data/changed.csv", "v1")
+	after := file("data/changed.csv", "version two")
+	added := file("data/added.csv", "added")
+	// Manifests written by older versions of DVC hold no sizes, the remote does
+	after.Size, added.Size = nil, nil
+	putObject(t, connections, scratch, after.MD5[:2]+"/"+after.MD5[2:], "version two")
+	putObject(t, connections, scratch, added.MD5[:2]+"/"+added.MD5[2:], "added")
+	from := putManifest(t, connections, scratch, kept, removed, before)
+	to := putManifest(t, connections, scratch, kept, after, added)
+	checksum := func(key string) string {
+		return strings.Replace(strings.TrimSuffix(key, ".dir"), "/", "", 1)
+	}
+	size := func(n int64) *int64 { return &n }
+
+	response, body := do(t, http.MethodGet, server.URL+"/remote/diff?remote=scratch&from="+checksum(from)+"&to="+checksum(to)+".dir", "", nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, body)
+	}
+	var got diffResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := diffResponse{
+		From:       checksum(from) + ".dir",
+		To:         checksum(to) + ".dir",
+		Added:      []diffFile{{added.RelPath, added.MD5, size(5)}},
+		Removed:    []diffFile{{removed.RelPath, removed.MD5, size(6)}},
+		Modified:   []modifiedFile{{before.RelPath, before.MD5, after.MD5, size(2), size(11)}},
+		Unchanged:  1,
+		Delta:      5 - 6 + 11 - 2,
+		SizesKnown: true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff %+v, want %+v", got, want)
+	}
+
+	// A file in neither the manifest nor the remote has no size
+	lost := file("lost.csv", "never pushed")
+	lost.Size = nil
+	to = putManifest(t, connections, scratch, kept, removed, before, lost)
+	response, body = do(t, http.MethodGet, server.URL+"/remote/diff?remote=scratch&from="+checksum(from)+"&to="+checksum(to), "", nil)
+	got = diffResponse{}
+	if err := json.Unmarshal([]byte(body), &got); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, body)
+	}
+	if len(got.Added) != 1 || got.Added[0].Size != nil || got.Delta != 0 || got.SizesKnown {
+		t.Errorf("diff with an unknown size %+v", got)
+	}
+}
+
+func TestDiffManifestsInvalid(t *testing.T) {
+	server, connections, loader := testServer(t)
+	key := putManifest(t, connections, loader[0], file("a.csv", "a"))
+	checksum := strings.Replace(strings.TrimSuffix(key, ".dir"), "/", "", 1)
+	absent, _ := object("absent manifest")
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"no to", "from=" + checksum, http.StatusBadRequest},
+		{"not hex", "from=" + checksum + "&to=zz" + checksum[2:], http.StatusBadRequest},
+		{"path", "from=" + checksum + "&to=../../etc/passwd", http.StatusBadRequest},
+		{"missing manifest", "from=" + checksum + "&to=" + strings.Replace(absent, "/", "", 1), http.StatusNotFound},
+	}
+	for _, test := range tests {
+		response, _ := do(t, http.MethodGet, server.URL+"/remote/diff?remote=scratch&"+test.query, "", nil)
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+const (
+	defaultBatchConcurrency = 16
+	maxExistsBody           = 16 << 20
+)
+
+// objectRef names an object by the md5 DVC stores it under, with the size
+// it is expected to have when known. Its JSON form is either the md5 alone or
+// an object holding the md5 and the size.
+type objectRef struct {
+	MD5  string `json:"md5"`
+	Size *int64 `json:"size,omitempty"`
+}
+
+func (ref *objectRef) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*ref = objectRef{}
+		return json.Unmarshal(data, &ref.MD5)
+	}
+	type plain objectRef
+	return json.Unmarshal(data, (*plain)(ref))
+}
+
+func (ref objectRef) validate() error {
+	sum, err := hex.DecodeString(strings.TrimSuffix(ref.MD5, ".dir"))
+	if err != nil || len(sum) != 16 {
+		return fmt.Errorf("%q is not a valid md5", ref.MD5)
+	}
+	return nil
+}
+
+// key is the key of the object, laid out as the routes of Attach store it.
+// DVC writes the md5s in lower case, whatever case they are given in.
+func (ref objectRef) key() string {
+	md5 := strings.ToLower(ref.MD5)
+	return md5[:2] + "/" + md5[2:]
+}
+
+type objectState int
+
+const (
+	objectPresent objectState = iota
+	objectMissing
+	objectMismatched
+	objectError
+)
+
+type objectStatus struct {
+	ref   objectRef
+	state objectState
+	size  int64
+	// md5 is the checksum the backend holds for the object, if any.
+	md5 []byte
+	err error
+}
+
+// checkObjects reads the attributes of the objects. The statuses are in the
+// order of refs.
+func checkObjects(ctx context.Context, bucket *blob.Bucket, refs []objectRef) []objectStatus {
+	statuses := make([]objectStatus, len(refs))
+	parallel(len(refs), func(i int) {
+		statuses[i] = checkObject(ctx, bucket, refs[i])
+	})
+	return statuses
+}
+
+// parallel calls fn for each index below n, BATCH_CONCURRENCY calls at a
+// time, and returns once all of them are done.
+func parallel(n int, fn func(i int)) {
+	concurrency := getEnvInt("BATCH_CONCURRENCY")
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func checkObject(ctx context.Context, bucket *blob.Bucket, ref objectRef) objectStatus {
+	status := objectStatus{ref: ref}
+	attrs, err := bucket.Attributes(ctx, ref.key())
+	switch {
+	case gcerrors.Code(err) == gcerrors.NotFound:
+		status.state = objectMissing
+	case err != nil:
+		status.state = objectError
+		status.err = err
+	case ref.Size != nil && *ref.Size != attrs.Size:
+		status.state = objectMismatched
+		status.size = attrs.Size
+	default:
+		status.state = objectPresent
+		status.size = attrs.Size
+		status.md5 = attrs.MD5
+	}
+	return status
+}
+
+type existsResponse struct {
+	Present    []string        `json:"present"`
+	Missing    []string        `json:"missing"`
+	Mismatched []mismatchEntry `json:"mismatched"`
+	Errors     []errorEntry    `json:"errors"`
+}
+
+type mismatchEntry struct {
+	MD5          string `json:"md5"`
+	Size         int64  `json:"size"`
+	ExpectedSize int64  `json:"expected_size"`
+}
+
+type errorEntry struct {
+	MD5   string `json:"md5"`
+	Error string `json:"error"`
+}
+
+func newExistsResponse(statuses []objectStatus) existsResponse {
+	response := existsResponse{
+		Present:    []string{},
+		Missing:    []string{},
+		Mismatched: []mismatchEntry{},
+		Errors:     []errorEntry{},
+	}
+	for _, status := range statuses {
+		switch status.state {
+		case objectPresent:
+			response.Present = append(response.Present, status.ref.MD5)
+		case objectMissing:
+			response.Missing = append(response.Missing, status.ref.MD5)
+		case objectMismatched:
+			response.Mismatched = append(response.Mismatched, mismatchEntry{status.ref.MD5, status.size, *status.ref.Size})
+		case objectError:
+			response.Errors = append(response.Errors, errorEntry{status.ref.MD5, status.err.Error()})
+		}
+	}
+	return response
+}
+
+// CheckExists takes a JSON list of md5s, each optionally with its size, and
+// reports which objects of the remote are present, missing or of another size,
+// so a whole dataset is verified in one request.
+func (h Handler) CheckExists(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	params := parseVars(r)
+
+	var refs []objectRef
+	if errDecode := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxExistsBody)).Decode(&refs); errDecode != nil {
+		// Write an error and stop the handler chain
+		log.
+			WithError(errDecode).
+			Error("Cannot decode object list")
+		http.Error(w, "Body must be a JSON list of md5s", http.StatusBadRequest)
+		return
+	}
+	for _, ref := range refs {
+		if errValid := ref.validate(); errValid != nil {
+			// Write an error and stop the handler chain
+			log.
+				WithError(errValid).
+				Error("Invalid object in list")
+			http.Error(w, errValid.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	conn, errGet := h.getConnection(params, auth.Read, w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
+		log.
+			WithError(errGet).
+			Error("GetConnection Error")
+		return
+	}
+	defer conn.Close()
+
+	response := newExistsResponse(checkObjects(r.Context(), conn.Bucket, refs))
+	log.
+		WithField("remote", params.remote).
+		WithField("objects", len(refs)).
+		WithField("missing", len(response.Missing)).
+		WithField("mismatched", len(response.Mismatched)).
+		Info("Checked objects")
+	writeJSON(w, response)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.
+			WithError(err).
+			Error("Cannot write response")
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// upload puts content in remote and returns its md5.
+func upload(t *testing.T, serverURL, remote, content string) string {
+	t.Helper()
+	key, contentMD5 := object(content)
+	response, _ := do(t, http.MethodPut, serverURL+"/remote/"+key+"?remote="+remote, content, http.Header{"Content-Md5": {contentMD5}})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("upload status %d", response.StatusCode)
+	}
+	return strings.Replace(key, "/", "", 1)
+}
+
+func TestCheckExists(t *testing.T) {
+	server, _, _ := testServer(t)
+	present := upload(t, server.URL, "scratch", "present")
+	sized := upload(t, server.URL, "scratch", "0123456789")
+	missing, _ := object("missing")
+	missing = strings.Replace(missing, "/", "", 1)
+
+	body := fmt.Sprintf(`[%q, {"md5": %q, "size": 10}, {"md5": %q, "size": 3}, %q, %q]`,
+		present, sized, sized, missing, strings.ToUpper(present))
+	response, content := do(t, http.MethodPost, server.URL+"/remote/exists?remote=scratch", body, nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, content)
+	}
+	var got existsResponse
+	if err := json.Unmarshal([]byte(content), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := existsResponse{
+		// An upper case md5 names the same object
+		Present:    []string{present, sized, strings.ToUpper(present)},
+		Missing:    []string{missing},
+		Mismatched: []mismatchEntry{{MD5: sized, Size: 10, ExpectedSize: 3}},
+		Errors:     []errorEntry{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckExistsInvalid(t *testing.T) {
+	server, _, _ := testServer(t)
+	for name, body := range map[string]string{
+		"not JSON":      `present`,
+		"not a list":    `{"md5": "d41d8cd98f00b204e9800998ecf8427e"}`,
+		"not hex":       `["zz1d8cd98f00b204e9800998ecf8427e"]`,
+		"short md5":     `["d41d8cd98f00b204"]`,
+		"path":          `["../../../etc/passwd"]`,
+		"wrong size":    `[{"md5": "d41d8cd98f00b204e9800998ecf8427e", "size": "big"}]`,
+		"upper .dir":    `["d41d8cd98f00b204e9800998ecf8427e.DIR"]`,
+		"empty element": `[""]`,
+	} {
+		response, _ := do(t, http.MethodPost, server.URL+"/remote/exists?remote=scratch", body, nil)
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, response.StatusCode)
+		}
+	}
+
+	// The ACL of the remote is checked
+	response, _ := do(t, http.MethodPost, server.URL+"/remote/exists?remote=private", `[]`, nil)
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous check of private: status %d, want 401", response.StatusCode)
+	}
+}
+
+func TestParallelBound(t *testing.T) {
+	t.Setenv("BATCH_CONCURRENCY", "3")
+	var mu sync.Mutex
+	running, peak, calls := 0, 0, 0
+	parallel(20, func(i int) {
+		mu.Lock()
+		running++
+		calls++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	if calls != 20 {
+		t.Errorf("%d calls, want 20", calls)
+	}
+	if peak > 3 {
+		t.Errorf("%d calls at once, want at most 3", peak)
+	}
+}
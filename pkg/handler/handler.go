@@ -1,19 +1,24 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/httputil"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/bucketfs"
 	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"github.com/atekoa/dvc-http-remote/pkg/storage"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"gocloud.dev/blob"
@@ -21,87 +26,128 @@ import (
 )
 
 type StorageSiteLoader interface {
-	LoadConfig(local bool) (*pool.ConnectionConfig, error)
+	LoadConfig(remote string) (*pool.ConnectionConfig, error)
 }
 
 type Handler struct {
 	StorageLoader StorageSiteLoader
+	Pool          *pool.Pool
 }
 
-func (h *Handler) getConnection(params params, w http.ResponseWriter, r *http.Request) (*pool.CloudConn, error) {
-	connectionConfig, errLoad := h.StorageLoader.LoadConfig(params.remoteID == 0)
+// getConnection returns a connection to the remote of the request, once the
+// ACL of the remote grants the access to the caller.
+func (h *Handler) getConnection(params params, access auth.Access, w http.ResponseWriter, r *http.Request) (*pool.CloudConn, error) {
+	connectionConfig, errAuthorize := h.authorize(params, access, w, r)
+	if errAuthorize != nil {
+		// authorize already wrote the error, stop the handler chain
+		return nil, errAuthorize
+	}
+
+	conn, errGet := h.Pool.Get(r.Context(), connectionConfig)
+	if errGet != nil {
+		// Write an error and stop the handler chain
+		log.
+			WithField("remote", params.remote).
+			WithField("type", connectionConfig.Type).
+			WithError(errGet).
+			Error("Cannot create connections")
+		http.Error(w, "Cannot create connections", http.StatusBadGateway)
+		return nil, errGet
+	}
+	return conn, nil
+}
+
+// authorize returns the configuration of the remote of the request when its
+// ACL grants the access to the caller. Like getConnection, it writes the
+// error response itself.
+func (h *Handler) authorize(params params, access auth.Access, w http.ResponseWriter, r *http.Request) (*pool.ConnectionConfig, error) {
+	connectionConfig, errLoad := h.StorageLoader.LoadConfig(params.remote)
+	if errors.Is(errLoad, storage.ErrRemoteNotFound) {
+		// Write an error and stop the handler chain
+		log.
+			WithField("remote", params.remote).
+			WithError(errLoad).
+			Error("Unknown remote")
+		http.Error(w, "Unknown remote", http.StatusNotFound)
+		return nil, errLoad
+	}
 	if errLoad != nil {
 		// Write an error and stop the handler chain
 		log.
-			WithField("remoteID", params.remoteID).
+			WithField("remote", params.remote).
 			WithError(errLoad).
 			Error("Cannot load configuration")
-		http.Error(w, "Cannot load configuration", http.StatusForbidden)
+		http.Error(w, "Cannot load configuration", http.StatusInternalServerError)
 		return nil, errLoad
 	}
-	remoteType := connectionConfig.Type
 
-	switch remoteType {
-	case pool.ConfigTypeAzure:
-		connJisap, errGet := connectionConfig.OpenAzure(r.Context())
-		if errGet != nil {
-			// Write an error and stop the handler chain
-			log.
-				WithField("remoteID", params.remoteID).
-				WithError(errGet).
-				Error("Cannot load configuration")
-			http.Error(w, "Cannot load configuration", http.StatusForbidden)
-			return nil, errGet
+	id := auth.FromContext(r.Context())
+	if errACL := connectionConfig.ACL.Check(id, access, r, params.remote); errACL != nil {
+		// Write an error and stop the handler chain
+		entry := log.
+			WithField("remote", params.remote).
+			WithField("access", access).
+			WithError(errACL)
+		if id != nil {
+			entry = entry.WithField("user", id.User)
 		}
-		return &connJisap, nil
-	case pool.ConfigTypeHttp:
-		connJisap, errGet := connectionConfig.OpenHttp(r.Context())
-		if errGet != nil {
-			// Write an error and stop the handler chain
-			log.
-				WithField("remoteID", params.remoteID).
-				WithError(errGet).
-				Error("Cannot load configuration")
-			http.Error(w, "Cannot load configuration", http.StatusForbidden)
-			return nil, errGet
+		entry.Warn("Access denied")
+		if errors.Is(errACL, auth.ErrAuthenticationRequired) {
+			auth.Unauthorized(w, r, fmt.Sprintf("Authentication required for %s access to remote %s", access, remoteName(connectionConfig)))
+			return nil, errACL
 		}
-		return &connJisap, nil
-	default:
-		log.
-			WithField("remoteID", params.remoteID).
-			Error("Cannot create connections")
-		http.Error(w, "Cannot create connections", http.StatusForbidden)
-		return nil, errors.New("Cannot create connections")
+		http.Error(w, fmt.Sprintf("User %s has no %s access to remote %s", id.User, access, remoteName(connectionConfig)), http.StatusForbidden)
+		return nil, errACL
 	}
+	return connectionConfig, nil
 }
 
-func (h Handler) HeadFile(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	requestDump, err := httputil.DumpRequest(r, true)
-	if err != nil {
-		fmt.Println(err)
+// redirectToSignedURL redirects the download of key to a read-only signed URL
+// of the backend, so the bytes do not go through the proxy. When the URL
+// cannot be signed it writes nothing and returns false, and the object is
+// served by the proxy.
+func redirectToSignedURL(w http.ResponseWriter, r *http.Request, conn *pool.CloudConn, key string, expiry time.Duration) bool {
+	signedURL, errSign := conn.SignedURL(r.Context(), key, &blob.SignedURLOptions{
+		Method: http.MethodGet,
+		Expiry: expiry,
+	})
+	if errSign != nil {
+		log.
+			WithField("key", key).
+			WithError(errSign).
+			Warn("Cannot sign URL, serving the object")
+		return false
 	}
-	fmt.Println(string(requestDump))
 
-	params, errVars := parseVars(r)
-	if errVars != nil {
-		// Write an error and stop the handler chain
-		log.
-			WithField("err", errVars).
-			WithField("key", params.key).
-			Error("Cannot parse vars")
-		http.Error(w, "Cannot parse vars", http.StatusBadRequest)
-		return
+	// The URL expires, it must not be cached past that
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, signedURL, http.StatusTemporaryRedirect)
+	log.
+		WithField("key", key).
+		WithField("expiry", expiry).
+		Info("Redirected download")
+	return true
+}
+
+// remoteName names a remote in the error messages.
+func remoteName(config *pool.ConnectionConfig) string {
+	if config.Name != "" {
+		return config.Name
 	}
+	return strconv.Itoa(config.RemoteId)
+}
 
-	conn, errGet := h.getConnection(params, w, r)
+func (h Handler) HeadFile(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	params := parseVars(r)
+
+	conn, errGet := h.getConnection(params, auth.Read, w, r)
 	if errGet != nil {
-		// Write an error and stop the handler chain
+		// getConnection already wrote the error, stop the handler chain
 		log.
 			WithError(errGet).
 			WithField("key", params.key).
 			Error("GetConnection Error")
-		http.Error(w, "GetConnection Error", http.StatusBadGateway)
 		return
 	}
 	defer conn.Close()
@@ -143,41 +189,26 @@ func (h Handler) HeadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Content-Length", strconv.FormatInt(attrs.Size, 10))
 	w.Header().Set("Content-Type", attrs.ContentType)
 	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", base64.StdEncoding.EncodeToString(attrs.MD5)))
-	w.Header().Set("Last-Modified", attrs.ModTime.Format(time.RFC1123))
+	w.Header().Set("Last-Modified", attrs.ModTime.UTC().Format(http.TimeFormat))
 	w.Header().Set("Cache-Control", attrs.CacheControl)
 
 }
 
 func (h Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	requestDump, err := httputil.DumpRequest(r, true)
-	if err != nil {
-		fmt.Println(err)
-	}
-	fmt.Println(string(requestDump))
-
-	params, errVars := parseVars(r)
-	if errVars != nil {
-		// Write an error and stop the handler chain
-		log.
-			WithField("err", errVars).
-			WithField("key", params.key).
-			Error("Cannot parse vars")
-		http.Error(w, "Cannot parse vars", http.StatusBadRequest)
-		return
-	}
+	params := parseVars(r)
 
-	conn, errGet := h.getConnection(params, w, r)
+	conn, errGet := h.getConnection(params, auth.Read, w, r)
 	if errGet != nil {
-		// Write an error and stop the handler chain
+		// getConnection already wrote the error, stop the handler chain
 		log.
 			WithError(errGet).
 			WithField("key", params.key).
 			Error("GetConnection Error")
-		http.Error(w, "GetConnection Error", http.StatusBadGateway)
 		return
 	}
 	defer conn.Close()
@@ -199,18 +230,6 @@ func (h Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
-	reader, err := conn.Bucket.NewReader(r.Context(), params.key, &blob.ReaderOptions{})
-	if err != nil {
-		// Write an error and stop the handler chain
-		log.
-			WithError(err).
-			WithField("key", params.key).
-			Error("Error creating Bucket Reader")
-		http.Error(w, "Error creating Bucket Reader", http.StatusBadGateway)
-		return
-	}
-	defer reader.Close()
-
 	attrs, err := conn.Attributes(r.Context(), params.key)
 	if gcerrors.Code(err) == gcerrors.NotFound {
 		// Write an error and stop the handler chain
@@ -230,13 +249,64 @@ func (h Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Length", strconv.FormatInt(attrs.Size, 10))
+	if redirect := conn.Config().Redirect; redirect.Enabled && attrs.Size >= redirect.MinSize {
+		if redirectToSignedURL(w, r, conn, params.key, redirect.Expiry) {
+			return
+		}
+	}
+
+	etag := fmt.Sprintf("\"%s\"", base64.StdEncoding.EncodeToString(attrs.MD5))
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Content-Type", attrs.ContentType)
-	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", base64.StdEncoding.EncodeToString(attrs.MD5)))
-	w.Header().Set("Last-Modified", attrs.ModTime.Format(time.RFC1123))
+	w.Header().Set("ETag", etag)
+	if conn.Config().Compression.Enabled {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	w.Header().Set("Last-Modified", attrs.ModTime.UTC().Format(http.TimeFormat))
 	w.Header().Set("Cache-Control", attrs.CacheControl)
 
-	n, err := reader.WriteTo(w)
+	encoding := ""
+	if !conditional(r) {
+		encoding = negotiateEncoding(params.acceptEncoding, conn.Config().Compression, params.key, attrs)
+	}
+
+	var n int64
+	if encoding == "" {
+		// ServeContent answers the ranges, If-Range and the other
+		// preconditions against the ETag and Last-Modified set above.
+		content := bucketfs.NewReadSeeker(r.Context(), conn.Bucket, params.key, attrs.Size)
+		defer content.Close()
+		http.ServeContent(w, r, "", attrs.ModTime, content)
+		n, err = content.BytesRead(), content.Err()
+	} else {
+		var reader *blob.Reader
+		reader, err = conn.Bucket.NewReader(r.Context(), params.key, &blob.ReaderOptions{})
+		if err != nil {
+			// Write an error and stop the handler chain
+			log.
+				WithError(err).
+				WithField("key", params.key).
+				Error("Error creating Bucket Reader")
+			http.Error(w, "Error creating Bucket Reader", http.StatusBadGateway)
+			return
+		}
+		defer reader.Close()
+
+		// The encoded representation is a different entity: it gets its own
+		// ETag and its length is unknown until it is fully written.
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("ETag", fmt.Sprintf("\"%s-%s\"", base64.StdEncoding.EncodeToString(attrs.MD5), encoding))
+		w.Header().Del("Content-Length")
+
+		var encoder io.WriteCloser
+		encoder, err = newEncoder(encoding, w)
+		if err == nil {
+			n, err = reader.WriteTo(encoder)
+			if errEncoder := encoder.Close(); err == nil {
+				err = errEncoder
+			}
+		}
+	}
 	if err != nil {
 		switch err {
 		case context.Canceled:
@@ -267,37 +337,54 @@ func (h Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 
 func (h Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	requestDump, err := httputil.DumpRequest(r, false)
-	if err != nil {
-		fmt.Println(err)
-	}
-	fmt.Println(string(requestDump))
+	params := parseVars(r)
 
-	params, errVars := parseVars(r)
-	if errVars != nil {
-		// Write an error and stop the handler chain
+	conn, errGet := h.getConnection(params, auth.Write, w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
 		log.
-			WithField("err", errVars).
+			WithError(errGet).
 			WithField("key", params.key).
-			Error("Cannot parse vars")
-		http.Error(w, "Cannot parse vars", http.StatusBadRequest)
+			Error("GetConnection Error")
 		return
 	}
+	defer conn.Close()
 
-	conn, errGet := h.getConnection(params, w, r)
-	if errGet != nil {
-		// Write an error and stop the handler chain
+	// Objects are content addressed: the key is the MD5 of the content, so the
+	// writer refuses to commit anything whose hash does not match its name.
+	contentMD5, errChecksum := params.contentMD5()
+	if errChecksum != nil {
 		log.
-			WithError(errGet).
+			WithError(errChecksum).
 			WithField("key", params.key).
-			Error("GetConnection Error")
-		http.Error(w, "GetConnection Error", http.StatusBadGateway)
+			Error("Key is not a valid checksum")
+		http.Error(w, "Key is not a valid checksum", http.StatusBadRequest)
 		return
 	}
-	defer conn.Close()
+	if params.declaredMD5 != "" {
+		declared, errDeclared := base64.StdEncoding.DecodeString(params.declaredMD5)
+		if errDeclared != nil || !bytes.Equal(declared, contentMD5) {
+			log.
+				WithField("key", params.key).
+				WithField("Content-MD5", params.declaredMD5).
+				Error("Content-MD5 does not match the key")
+			http.Error(w, "Content-MD5 does not match the key", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Everything above is checked before the body is read, so a client
+	// sending "Expect: 100-continue" is rejected without uploading anything:
+	// net/http only answers 100 Continue on the first read of the body.
+
+	// Cancelling the writer context before Close aborts the write, so a failed
+	// upload never leaves a partial blob behind.
+	writeCtx, abort := context.WithCancel(r.Context())
+	defer abort()
 
-	writer, errWriter := conn.Bucket.NewWriter(r.Context(), params.key, &blob.WriterOptions{
+	writer, errWriter := conn.Bucket.NewWriter(writeCtx, params.key, &blob.WriterOptions{
 		ContentType: params.contentType,
+		ContentMD5:  contentMD5,
 		BufferSize:  getEnvInt("UPLOAD_BUFFER_SIZE"),
 	})
 	if errWriter != nil {
@@ -310,30 +397,70 @@ func (h Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	num_bytes, errCopy := io.Copy(writer, r.Body)
+	if errCopy == nil && r.ContentLength != -1 && num_bytes != r.ContentLength {
+		errCopy = io.ErrUnexpectedEOF
+	}
 	if errCopy != nil {
+		abort()
+		writer.Close()
 		log.
 			WithField("key", params.key).
 			WithField("Bytes written", num_bytes).
 			WithField("Content-Length", r.ContentLength).
 			WithError(errCopy).
-			Error("Failed to copy content")
-		http.Error(w, "Failed to copy content", http.StatusConflict)
+			Error("Failed to copy content, upload aborted")
+		status := uploadErrorStatus(r.Context(), errCopy)
+		http.Error(w, http.StatusText(status), status)
 		return
 	}
-	defer writer.Close()
 
-	if r.ContentLength != -1 && int64(num_bytes) != r.ContentLength {
+	if errClose := writer.Close(); errClose != nil {
 		log.
 			WithField("key", params.key).
 			WithField("Bytes written", num_bytes).
 			WithField("Content-Length", r.ContentLength).
-			Warn("Content Length is different from copied bytes")
-	} else {
-		log.
-			WithField("key", params.key).
-			WithField("Bytes written", num_bytes).
-			WithField("Content-Length", r.ContentLength).
-			Info("Upload FINISH!")
+			WithField("Code", gcerrors.Code(errClose)).
+			WithError(errClose).
+			Error("Failed to commit content")
+		status := uploadErrorStatus(r.Context(), errClose)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	log.
+		WithField("key", params.key).
+		WithField("Bytes written", num_bytes).
+		WithField("Content-Length", r.ContentLength).
+		Info("Upload FINISH!")
+}
+
+// uploadErrorStatus maps an error raised while streaming or committing an
+// upload to the status code reported back to the client.
+func uploadErrorStatus(ctx context.Context, err error) int {
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// body shorter than announced
+		return http.StatusBadRequest
+	case errors.Is(ctx.Err(), context.Canceled):
+		// canceled by user, nobody will read the response
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.InvalidArgument, gcerrors.FailedPrecondition:
+		// FailedPrecondition is raised when the content does not match its MD5
+		return http.StatusBadRequest
+	case gcerrors.PermissionDenied:
+		return http.StatusForbidden
+	case gcerrors.ResourceExhausted:
+		return http.StatusInsufficientStorage
+	case gcerrors.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case gcerrors.Canceled:
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
 	}
 }
 
@@ -346,9 +473,54 @@ func NewResponseWriter(w http.ResponseWriter) *responseWriter {
 	return &responseWriter{w, http.StatusOK}
 }
 
-func Attach(r *mux.Router, pathPrefix string, storage StorageSiteLoader) {
+func Attach(r *mux.Router, pathPrefix string, storage StorageSiteLoader, connections *pool.Pool) {
 	handler := Handler{
 		StorageLoader: storage,
+		Pool:          connections,
+	}
+
+	Restore := r.
+		Path(pathPrefix + "/trash/{folder}/{file}").
+		Methods("POST").
+		Subrouter()
+	Restore.Queries("remote", "{remote}").HandlerFunc(handler.RestoreFile)
+	Restore.NewRoute().HandlerFunc(handler.RestoreFile)
+
+	Exists := r.
+		Path(pathPrefix + "/exists").
+		Methods("POST").
+		Subrouter()
+	Exists.Queries("remote", "{remote}").HandlerFunc(handler.CheckExists)
+	Exists.NewRoute().HandlerFunc(handler.CheckExists)
+
+	Check := r.
+		Path(pathPrefix + "/check/{folder}/{file}").
+		Methods("GET").
+		Subrouter()
+	Check.Queries("remote", "{remote}").HandlerFunc(handler.CheckManifest)
+	Check.NewRoute().HandlerFunc(handler.CheckManifest)
+
+	Archive := r.
+		Path(pathPrefix + "/archive/{folder}/{file}").
+		Methods("GET").
+		Subrouter()
+	Archive.Queries("remote", "{remote}").HandlerFunc(handler.DownloadArchive)
+	Archive.NewRoute().HandlerFunc(handler.DownloadArchive)
+
+	Diff := r.
+		Path(pathPrefix + "/diff").
+		Methods("GET").
+		Subrouter()
+	Diff.Queries("remote", "{remote}").HandlerFunc(handler.DiffManifests)
+	Diff.NewRoute().HandlerFunc(handler.DiffManifests)
+
+	for _, path := range []string{"/browse/{folder}/{file}", "/browse/{folder}/{file}/{relpath:.*}"} {
+		Browse := r.
+			Path(pathPrefix + path).
+			Methods("GET").
+			Subrouter()
+		Browse.Queries("remote", "{remote}").HandlerFunc(handler.BrowseManifest)
+		Browse.NewRoute().HandlerFunc(handler.BrowseManifest)
 	}
 
 	UpDownV1 := r.
@@ -358,6 +530,8 @@ func Attach(r *mux.Router, pathPrefix string, storage StorageSiteLoader) {
 	UpDownV1.Methods("HEAD").HandlerFunc(handler.HeadFile)
 	UpDownV1.Methods("GET").HandlerFunc(handler.DownloadFile)
 	UpDownV1.Methods("POST").HandlerFunc(handler.UploadFile)
+	UpDownV1.Methods("PUT").HandlerFunc(handler.UploadFile)
+	UpDownV1.Methods("DELETE").HandlerFunc(handler.DeleteFile)
 
 	UpDownV2 := r.
 		Path(pathPrefix).
@@ -366,18 +540,30 @@ func Attach(r *mux.Router, pathPrefix string, storage StorageSiteLoader) {
 	UpDownV2.Methods("HEAD").HandlerFunc(handler.HeadFile)
 	UpDownV2.Methods("GET").HandlerFunc(handler.DownloadFile)
 	UpDownV2.Methods("POST").HandlerFunc(handler.UploadFile)
+	UpDownV2.Methods("PUT").HandlerFunc(handler.UploadFile)
+	UpDownV2.Methods("DELETE").HandlerFunc(handler.DeleteFile)
+
+	UpDownDefault := r.
+		Path(pathPrefix + "/{folder}/{file}").
+		Subrouter()
+	UpDownDefault.Methods("HEAD").HandlerFunc(handler.HeadFile)
+	UpDownDefault.Methods("GET").HandlerFunc(handler.DownloadFile)
+	UpDownDefault.Methods("POST").HandlerFunc(handler.UploadFile)
+	UpDownDefault.Methods("PUT").HandlerFunc(handler.UploadFile)
+	UpDownDefault.Methods("DELETE").HandlerFunc(handler.DeleteFile)
 }
 
 type params struct {
-	remoteID       int
+	remote         string
 	key            string
 	checksum       string
 	contentType    string
 	acceptEncoding string
 	rangeBytes     string
+	declaredMD5    string
 }
 
-func parseVars(r *http.Request) (params, error) {
+func parseVars(r *http.Request) params {
 	params := params{}
 
 	vars := mux.Vars(r)
@@ -387,18 +573,28 @@ func parseVars(r *http.Request) (params, error) {
 	params.key = folder + "/" + file
 	params.checksum = folder + strings.TrimSuffix(file, ".dir")
 
-	remote := vars["remote"]
-	remoteID, err := strconv.Atoi(remote)
-	if err != nil {
-		return params, fmt.Errorf("remote id is not a valid integer")
-	}
-	params.remoteID = remoteID
+	// Remotes are addressed by name, alias or id. Without one the default
+	// remote is used.
+	params.remote = vars["remote"]
 
 	params.contentType = r.Header.Get("Content-Type")
 	params.acceptEncoding = r.Header.Get("Accept-Encoding")
 	params.rangeBytes = r.Header.Get("Range")
+	params.declaredMD5 = r.Header.Get("Content-MD5")
+
+	return params
+}
 
-	return params, nil
+// contentMD5 decodes the MD5 the object key is derived from.
+func (p params) contentMD5() ([]byte, error) {
+	sum, err := hex.DecodeString(p.checksum)
+	if err != nil {
+		return nil, fmt.Errorf("checksum %q is not hexadecimal, %w", p.checksum, err)
+	}
+	if len(sum) != md5.Size {
+		return nil, fmt.Errorf("checksum %q is not a valid md5", p.checksum)
+	}
+	return sum, nil
 }
 
 func getEnvInt(key string) int {
@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/dvc"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"github.com/atekoa/dvc-http-remote/pkg/storage"
+	"github.com/gorilla/mux"
+	"gocloud.dev/blob"
+)
+
+// remotes is a StorageSiteLoader over a fixed set of remotes, the first one
+// being the default.
+type remotes []*pool.ConnectionConfig
+
+func (rs remotes) LoadConfig(remote string) (*pool.ConnectionConfig, error) {
+	for _, config := range rs {
+		if remote == "" || remote == config.Name {
+			return config, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", storage.ErrRemoteNotFound, remote)
+}
+
+// testServer serves Attach over two memory remotes: scratch open to everybody
+// and allowing delete, and private readable by authenticated users and
+// writable by alice. The X-User header of the requests sets their identity.
+func testServer(t *testing.T) (*httptest.Server, *pool.Pool, remotes) {
+	t.Helper()
+	loader := remotes{
+		{
+			Name:        "scratch",
+			Type:        pool.ConfigTypeMemory,
+			AllowDelete: true,
+			Trash:       pool.TrashPolicy{Prefix: ".trash"},
+		},
+		{
+			Name:     "private",
+			Type:     pool.ConfigTypeMemory,
+			RemoteId: 1,
+			ACL:      &auth.ACL{Read: []string{auth.Authenticated}, Write: []string{"alice"}},
+		},
+	}
+	server, connections := serve(t, loader)
+	return server, connections, loader
+}
+
+// serve serves Attach over the remotes of loader, the X-User header of the
+// requests setting their identity.
+func serve(t *testing.T, loader remotes) (*httptest.Server, *pool.Pool) {
+	t.Helper()
+	connections := pool.NewPool(0)
+	t.Cleanup(connections.Close)
+
+	router := mux.NewRouter()
+	Attach(router, "/remote", loader, connections)
+	identify := func(w http.ResponseWriter, r *http.Request) {
+		if user := r.Header.Get("X-User"); user != "" {
+			r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{User: user, Method: "test"}))
+		}
+		router.ServeHTTP(w, r)
+	}
+	server := httptest.NewServer(http.HandlerFunc(identify))
+	t.Cleanup(server.Close)
+	return server, connections
+}
+
+// object returns content with the key and Content-MD5 DVC derives from it.
+func object(content string) (key, contentMD5 string) {
+	sum := md5.Sum([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+	return checksum[:2] + "/" + checksum[2:], base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func do(t *testing.T, method, url string, body string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(b)
+}
+
+// stored reports whether key is in the bucket of config.
+func stored(t *testing.T, connections *pool.Pool, config *pool.ConnectionConfig, key string) bool {
+	t.Helper()
+	conn, err := connections.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	exists, err := conn.Exists(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+// putObject writes content under key in the bucket of config, bypassing the
+// checks of the routes.
+func putObject(t *testing.T, connections *pool.Pool, config *pool.ConnectionConfig, key, content string) {
+	t.Helper()
+	conn, err := connections.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteAll(context.Background(), key, []byte(content), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// file returns the manifest entry of a file of a dataset.
+func file(relpath, content string) dvc.ManifestEntry {
+	key, _ := object(content)
+	size := int64(len(content))
+	return dvc.ManifestEntry{MD5: strings.Replace(key, "/", "", 1), RelPath: relpath, Size: &size}
+}
+
+// putManifest writes the .dir object listing entries in the bucket of config
+// and returns its key.
+func putManifest(t *testing.T, connections *pool.Pool, config *pool.ConnectionConfig, entries ...dvc.ManifestEntry) string {
+	t.Helper()
+	content, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := object(string(content))
+	putObject(t, connections, config, key+".dir", string(content))
+	return key + ".dir"
+}
+
+func TestUploadDownload(t *testing.T) {
+	server, _, _ := testServer(t)
+	content := "0123456789abcdefghij"
+	key, contentMD5 := object(content)
+	url := server.URL + "/remote/" + key + "?remote=scratch"
+
+	response, _ := do(t, http.MethodPut, url, content, http.Header{"Content-Md5": {contentMD5}})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("upload status %d", response.StatusCode)
+	}
+
+	response, _ = do(t, http.MethodHead, url, "", nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("HEAD status %d", response.StatusCode)
+	}
+	if response.ContentLength != int64(len(content)) {
+		t.Errorf("HEAD Content-Length %d, want %d", response.ContentLength, len(content))
+	}
+	if etag := response.Header.Get("ETag"); etag != `"`+contentMD5+`"` {
+		t.Errorf("HEAD ETag %s, want the MD5 of the content", etag)
+	}
+
+	response, body := do(t, http.MethodGet, url, "", nil)
+	if response.StatusCode != http.StatusOK || body != content {
+		t.Errorf("GET %d %q, want %q", response.StatusCode, body, content)
+	}
+
+	// The default remote is scratch
+	response, body = do(t, http.MethodGet, server.URL+"/remote/"+key, "", nil)
+	if response.StatusCode != http.StatusOK || body != content {
+		t.Errorf("GET on the default remote %d %q", response.StatusCode, body)
+	}
+
+	missing, _ := object("missing")
+	response, _ = do(t, http.MethodHead, server.URL+"/remote/"+missing+"?remote=scratch", "", nil)
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of a missing object %d, want 404", response.StatusCode)
+	}
+	response, _ = do(t, http.MethodGet, server.URL+"/remote/"+key+"?remote=unknown", "", nil)
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("GET on an unknown remote %d, want 404", response.StatusCode)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	server, _, _ := testServer(t)
+	content := "0123456789abcdefghij"
+	key, _ := object(content)
+	url := server.URL + "/remote/" + key + "?remote=scratch"
+	if response, _ := do(t, http.MethodPut, url, content, nil); response.StatusCode != http.StatusOK {
+		t.Fatalf("upload status %d", response.StatusCode)
+	}
+
+	tests := []struct {
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/20"},
+		{"bytes=-3", http.StatusPartialContent, "hij", "bytes 17-19/20"},
+		{"bytes=30-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+	}
+	for _, test := range tests {
+		response, body := do(t, http.MethodGet, url, "", http.Header{"Range": {test.rangeHeader}})
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.rangeHeader, response.StatusCode, test.status)
+			continue
+		}
+		if got := response.Header.Get("Content-Range"); got != test.contentRange {
+			t.Errorf("%s: Content-Range %q, want %q", test.rangeHeader, got, test.contentRange)
+		}
+		if test.status == http.StatusPartialContent && body != test.body {
+			t.Errorf("%s: body %q, want %q", test.rangeHeader, body, test.body)
+		}
+	}
+}
+
+func TestUploadChecksumMismatch(t *testing.T) {
+	server, connections, loader := testServer(t)
+	key, contentMD5 := object("expected content")
+
+	// The body does not hash to the key
+	response, _ := do(t, http.MethodPut, server.URL+"/remote/"+key+"?remote=scratch", "other content", nil)
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("upload of a corrupted body %d, want 400", response.StatusCode)
+	}
+	if stored(t, connections, loader[0], key) {
+		t.Error("the corrupted upload was stored")
+	}
+
+	// Content-MD5 does not match the key
+	_, otherMD5 := object("other content")
+	response, _ = do(t, http.MethodPut, server.URL+"/remote/"+key+"?remote=scratch", "expected content", http.Header{"Content-Md5": {otherMD5}})
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("upload with a wrong Content-MD5 %d, want 400", response.StatusCode)
+	}
+	if stored(t, connections, loader[0], key) {
+		t.Error("the upload with a wrong Content-MD5 was stored")
+	}
+
+	// Sanity check, the right Content-MD5 is accepted
+	response, _ = do(t, http.MethodPut, server.URL+"/remote/"+key+"?remote=scratch", "expected content", http.Header{"Content-Md5": {contentMD5}})
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("upload %d, want 200", response.StatusCode)
+	}
+}
+
+func TestDeleteToTrash(t *testing.T) {
+	server, connections, loader := testServer(t)
+	content := "deleted content"
+	key, _ := object(content)
+	url := server.URL + "/remote/" + key + "?remote=scratch"
+	if response, _ := do(t, http.MethodPut, url, content, nil); response.StatusCode != http.StatusOK {
+		t.Fatalf("upload status %d", response.StatusCode)
+	}
+
+	if response, _ := do(t, http.MethodDelete, url, "", nil); response.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE status %d", response.StatusCode)
+	}
+	if response, _ := do(t, http.MethodGet, url, "", nil); response.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of a deleted object %d, want 404", response.StatusCode)
+	}
+
+	conn, err := connections.Get(context.Background(), loader[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _, err := conn.ListPage(context.Background(), blob.FirstPageToken, 10, &blob.ListOptions{Prefix: ".trash/" + key + "/"})
+	conn.Close()
+	if err != nil || len(page) != 1 {
+		t.Fatalf("trash holds %d copies, %v", len(page), err)
+	}
+
+	if response, _ := do(t, http.MethodPost, server.URL+"/remote/trash/"+key+"?remote=scratch", "", nil); response.StatusCode != http.StatusOK {
+		t.Fatalf("restore status %d", response.StatusCode)
+	}
+	if response, body := do(t, http.MethodGet, url, "", nil); response.StatusCode != http.StatusOK || body != content {
+		t.Errorf("GET of a restored object %d %q", response.StatusCode, body)
+	}
+
+	// Delete is not allowed on private
+	if response, _ := do(t, http.MethodDelete, server.URL+"/remote/"+key+"?remote=private", "", http.Header{"X-User": {"alice"}}); response.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE on a remote without delete %d, want 403", response.StatusCode)
+	}
+}
+
+func TestACL(t *testing.T) {
+	server, _, _ := testServer(t)
+	content := "private content"
+	key, _ := object(content)
+	url := server.URL + "/remote/" + key + "?remote=private"
+
+	tests := []struct {
+		name   string
+		method string
+		user   string
+		status int
+	}{
+		{"anonymous write", http.MethodPut, "", http.StatusUnauthorized},
+		{"reader write", http.MethodPut, "bob", http.StatusForbidden},
+		{"writer write", http.MethodPut, "alice", http.StatusOK},
+		{"anonymous read", http.MethodGet, "", http.StatusUnauthorized},
+		{"anonymous head", http.MethodHead, "", http.StatusUnauthorized},
+		{"reader read", http.MethodGet, "bob", http.StatusOK},
+		{"writer read", http.MethodGet, "alice", http.StatusOK},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.user != "" {
+			header.Set("X-User", test.user)
+		}
+		body := ""
+		if test.method == http.MethodPut {
+			body = content
+		}
+		response, _ := do(t, test.method, url, body, header)
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+	}
+}
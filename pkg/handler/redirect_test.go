@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+)
+
+// fakeS3 stores the objects of PUT requests and serves them back, enough for
+// a remote whose URLs are signed without reaching the backend.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestDownloadRedirect(t *testing.T) {
+	backend := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	t.Cleanup(backend.Close)
+	redirect := pool.RedirectPolicy{Enabled: true, MinSize: 10, Expiry: time.Minute}
+	loader := remotes{
+		{
+			Name:          "signed",
+			Type:          pool.ConfigTypeS3,
+			ContainerName: "bucket",
+			S3: pool.S3Config{
+				Endpoint:        backend.URL,
+				PathStyle:       true,
+				AccessKeyID:     "key",
+				SecretAccessKey: "secret",
+			},
+			Redirect: redirect,
+		},
+		{
+			// The memory remotes cannot sign URLs
+			Name:     "unsigned",
+			Type:     pool.ConfigTypeMemory,
+			RemoteId: 1,
+			Redirect: redirect,
+		},
+	}
+	server, connections := serve(t, loader)
+	large, small := "0123456789abcdef", "0123"
+	largeKey, _ := object(large)
+	smallKey, _ := object(small)
+	for _, config := range loader {
+		putObject(t, connections, config, largeKey, large)
+		putObject(t, connections, config, smallKey, small)
+	}
+	// The redirects are checked, not followed
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(method, remote, key string) (*http.Response, string) {
+		t.Helper()
+		request, err := http.NewRequest(method, server.URL+"/remote/"+key+"?remote="+remote, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response, string(body)
+	}
+
+	response, _ := get(http.MethodGet, "signed", largeKey)
+	if response.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("large object: status %d, want 307", response.StatusCode)
+	}
+	if cacheControl := response.Header.Get("Cache-Control"); cacheControl != "no-store" {
+		t.Errorf("redirect Cache-Control %q, want no-store", cacheControl)
+	}
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Host != backend.Listener.Addr().String() || location.Path != "/bucket/"+largeKey || location.Query().Get("X-Amz-Signature") == "" {
+		t.Errorf("redirected to %s, want a signed URL of the backend", location)
+	}
+
+	// Smaller objects, the HEAD requests and the remotes that cannot sign
+	// are served by the proxy
+	for _, test := range []struct {
+		name, method, remote, key, body string
+	}{
+		{"small object", http.MethodGet, "signed", smallKey, small},
+		{"HEAD", http.MethodHead, "signed", largeKey, ""},
+		{"signing failure", http.MethodGet, "unsigned", largeKey, large},
+	} {
+		response, body := get(test.method, test.remote, test.key)
+		if response.StatusCode != http.StatusOK || body != test.body {
+			t.Errorf("%s: status %d, %q, want %q", test.name, response.StatusCode, body, test.body)
+		}
+		if location := response.Header.Get("Location"); location != "" {
+			t.Errorf("%s: redirected to %s", test.name, location)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultSignedURLExpiry = time.Hour
+
+type signHandler struct {
+	Handler
+	pathPrefix string
+	signer     *auth.SignedURLs
+}
+
+type signRequest struct {
+	MD5    string `json:"md5"`
+	Method string `json:"method"`
+	// ExpiresIn is a duration such as 30m, one hour by default.
+	ExpiresIn string `json:"expires_in"`
+}
+
+type signResponse struct {
+	URL     string    `json:"url"`
+	Method  string    `json:"method"`
+	Expires time.Time `json:"expires"`
+}
+
+// AttachSigning serves pathPrefix/sign, which hands out temporary URLs of the
+// /{folder}/{file} routes. They are verified by signer, one of the
+// authenticators in front of the router.
+func AttachSigning(r *mux.Router, pathPrefix string, storage StorageSiteLoader, connections *pool.Pool, signer *auth.SignedURLs) {
+	handler := &signHandler{
+		Handler: Handler{
+			StorageLoader: storage,
+			Pool:          connections,
+		},
+		pathPrefix: pathPrefix,
+		signer:     signer,
+	}
+
+	Sign := r.
+		Path(pathPrefix + "/sign").
+		Methods("POST").
+		Subrouter()
+	Sign.Queries("remote", "{remote}").HandlerFunc(handler.SignURL)
+	Sign.NewRoute().HandlerFunc(handler.SignURL)
+}
+
+// SignURL returns a URL allowing GET or PUT on a single object until it
+// expires, to whoever holds it. The caller must have that access.
+func (h *signHandler) SignURL(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var request signRequest
+	if errDecode := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); errDecode != nil {
+		// Write an error and stop the handler chain
+		log.
+			WithError(errDecode).
+			Error("Cannot decode sign request")
+		http.Error(w, "Body must be a JSON object with md5, method and expires_in", http.StatusBadRequest)
+		return
+	}
+
+	ref := objectRef{MD5: request.MD5}
+	if errValid := ref.validate(); errValid != nil {
+		// Write an error and stop the handler chain
+		log.
+			WithError(errValid).
+			Error("Invalid object to sign")
+		http.Error(w, errValid.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var access auth.Access
+	switch request.Method {
+	case http.MethodGet:
+		access = auth.Read
+	case http.MethodPut:
+		access = auth.Write
+	default:
+		// Write an error and stop the handler chain
+		log.
+			WithField("method", request.Method).
+			Warn("Cannot sign method")
+		http.Error(w, "method must be GET or PUT", http.StatusBadRequest)
+		return
+	}
+
+	expiresIn := defaultSignedURLExpiry
+	if request.ExpiresIn != "" {
+		var errParse error
+		expiresIn, errParse = time.ParseDuration(request.ExpiresIn)
+		if errParse != nil || expiresIn <= 0 {
+			// Write an error and stop the handler chain
+			log.
+				WithField("expires_in", request.ExpiresIn).
+				Warn("Invalid expiry")
+			http.Error(w, "expires_in must be a positive duration such as 30m", http.StatusBadRequest)
+			return
+		}
+	}
+	if expiresIn > h.signer.MaxExpiry {
+		// Write an error and stop the handler chain
+		log.
+			WithField("expires_in", expiresIn).
+			Warn("Expiry is too long")
+		http.Error(w, "expires_in must not exceed "+h.signer.MaxExpiry.String(), http.StatusBadRequest)
+		return
+	}
+
+	params := params{remote: mux.Vars(r)["remote"], key: ref.key()}
+	if _, errAuthorize := h.authorize(params, access, w, r); errAuthorize != nil {
+		// authorize already wrote the error, stop the handler chain
+		return
+	}
+
+	expires := time.Now().Add(expiresIn).Truncate(time.Second)
+	signed := url.URL{
+		Scheme:   "http",
+		Host:     r.Host,
+		Path:     h.pathPrefix + "/" + params.key,
+		RawQuery: h.signer.Sign(request.Method, h.pathPrefix+"/"+params.key, params.remote, expires).Encode(),
+	}
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		signed.Scheme = "https"
+	}
+
+	entry := log.
+		WithField("remote", params.remote).
+		WithField("key", params.key).
+		WithField("method", request.Method).
+		WithField("expires", expires)
+	if id := auth.FromContext(r.Context()); id != nil {
+		entry = entry.WithField("user", id.User)
+	}
+	entry.Info("Signed URL")
+	writeJSON(w, signResponse{URL: signed.String(), Method: request.Method, Expires: expires.UTC()})
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/bucketfs"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"github.com/atekoa/dvc-http-remote/pkg/s3api"
+	"github.com/atekoa/dvc-http-remote/pkg/trash"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+	"golang.org/x/net/webdav"
+)
+
+// davRemote holds the WebDAV state shared by the requests to a remote.
+type davRemote struct {
+	locks webdav.LockSystem
+	dirs  *bucketfs.Dirs
+}
+
+type webdavHandler struct {
+	Handler
+	pathPrefix string
+
+	mu      sync.Mutex
+	remotes map[*pool.ConnectionConfig]*davRemote
+}
+
+// AttachWebDAV serves each remote as a WebDAV folder under
+// pathPrefix/webdav/{remote}/, which lets DVC's webdav remote list the store.
+// It must be attached before Attach, whose routes would otherwise take the
+// WebDAV paths of two segments.
+func AttachWebDAV(r *mux.Router, pathPrefix string, storage StorageSiteLoader, connections *pool.Pool) {
+	handler := &webdavHandler{
+		Handler: Handler{
+			StorageLoader: storage,
+			Pool:          connections,
+		},
+		pathPrefix: pathPrefix,
+		remotes:    map[*pool.ConnectionConfig]*davRemote{},
+	}
+
+	r.Path(pathPrefix + "/webdav/{remote}").HandlerFunc(handler.ServeWebDAV)
+	r.PathPrefix(pathPrefix + "/webdav/{remote}/").HandlerFunc(handler.ServeWebDAV)
+}
+
+func (h *webdavHandler) davRemote(config *pool.ConnectionConfig) *davRemote {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	remote, ok := h.remotes[config]
+	if !ok {
+		remote = &davRemote{
+			locks: webdav.NewMemLS(),
+			dirs:  bucketfs.NewDirs(),
+		}
+		h.remotes[config] = remote
+	}
+	return remote
+}
+
+func (h *webdavHandler) ServeWebDAV(w http.ResponseWriter, r *http.Request) {
+	params := params{
+		remote: mux.Vars(r)["remote"],
+	}
+
+	conn, errGet := h.getConnection(params, auth.AccessOf(r.Method), w, r)
+	if errGet != nil {
+		// getConnection already wrote the error, stop the handler chain
+		log.
+			WithError(errGet).
+			WithField("path", r.URL.Path).
+			Error("GetConnection Error")
+		return
+	}
+	defer conn.Close()
+
+	config := conn.Config()
+	if (r.Method == "DELETE" || r.Method == "MOVE") && !config.AllowDelete {
+		log.
+			WithField("remote", params.remote).
+			WithField("path", r.URL.Path).
+			Warn("Delete is not allowed on this remote")
+		http.Error(w, "Delete is not allowed on this remote", http.StatusForbidden)
+		return
+	}
+	if r.Method == "PUT" {
+		r = r.WithContext(bucketfs.WithExpectedSize(r.Context(), r.ContentLength))
+	}
+
+	remote := h.davRemote(config)
+	dav := &webdav.Handler{
+		Prefix: h.pathPrefix + "/webdav/" + params.remote,
+		FileSystem: &bucketfs.FS{
+			Bucket: conn.Bucket,
+			Hidden: []string{config.Trash.Prefix, s3api.MultipartPrefix},
+			Remove: func(ctx context.Context, bucket *blob.Bucket, key string) error {
+				return trash.Move(ctx, bucket, config.Trash, key)
+			},
+			Dirs: remote.dirs,
+		},
+		LockSystem: remote.locks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.
+					WithField("method", r.Method).
+					WithField("path", r.URL.Path).
+					WithError(err).
+					Warn("WebDAV request failed")
+			}
+		},
+	}
+	dav.ServeHTTP(w, r)
+}
@@ -8,10 +8,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/azureblob"
 	"gocloud.dev/blob/fileblob"
@@ -21,27 +23,150 @@ const (
 	ConfigTypeUnknown ConfigType = "unknown"
 	ConfigTypeAzure   ConfigType = "azure"
 	ConfigTypeHttp    ConfigType = "http"
+	ConfigTypeS3      ConfigType = "s3"
+	ConfigTypeGCS     ConfigType = "gcs"
+	ConfigTypeMemory  ConfigType = "memory"
 )
 
 type ConfigType string
 
+// azureClient is the HTTP client of every Azure pipeline. It uses a transport
+// that is different from http.DefaultTransport.
+var azureClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout:   1 * time.Hour,
+			KeepAlive: 1 * time.Hour,
+			DualStack: true,
+		}).DialContext,
+		MaxIdleConns:          1000,
+		MaxIdleConnsPerHost:   200,
+		MaxConnsPerHost:       200,
+		IdleConnTimeout:       60 * time.Minute,
+		TLSHandshakeTimeout:   60 * time.Minute,
+		ExpectContinueTimeout: 60 * time.Minute,
+	},
+}
+
 type ConnectionConfig struct {
-	Type ConfigType
-	URL  *url.URL
+	Name    string
+	Aliases []string
+	Type    ConfigType
+	URL     *url.URL
 
 	ContainerName string
+	// Prefix scopes every key of the remote under a folder of the container.
+	Prefix string
 
 	ConnectionString string
 	AccountName      string
 	AccountKey       string
 
+	S3     S3Config
+	GCS    GCSConfig
+	Memory MemoryConfig
+
 	RemoteId int
+
+	Compression CompressionPolicy
+
+	// AllowDelete grants DELETE on the remote. Deleted objects are kept in
+	// the trash of the remote until its retention is over.
+	AllowDelete bool
+	Trash       TrashPolicy
+
+	Redirect RedirectPolicy
+
+	// ACL lists who may read and write the remote. A nil ACL lets everybody
+	// in, as remotes did before authentication.
+	ACL *auth.ACL
+}
+
+// TrashPolicy drives where the deleted objects of a remote go and how long
+// they stay there.
+type TrashPolicy struct {
+	Prefix string
+	// Retention is how long deleted objects can be restored, zero keeps
+	// them forever.
+	Retention time.Duration
+}
+
+// RedirectPolicy sends the downloads of an Azure remote straight to the
+// storage account, with a redirect to a read-only SAS URL of the object.
+type RedirectPolicy struct {
+	Enabled bool
+	// MinSize is the smallest object, in bytes, redirected. Smaller ones,
+	// such as the .dir manifests, are served by the proxy.
+	MinSize int64
+	// Expiry is the lifetime of the SAS URLs.
+	Expiry time.Duration
+}
+
+// CompressionPolicy drives the response compression of a remote.
+type CompressionPolicy struct {
+	Enabled bool
+	// MinSize is the smallest object, in bytes, worth compressing.
+	MinSize int64
+	// ContentTypes lists the media types compressed, "text/" style entries
+	// match a whole family and "*" every type. The .dir manifests are always
+	// compressible, whatever their type.
+	ContentTypes []string
+	// SkipTypes lists the media types never compressed, even when they
+	// match ContentTypes, such as the images or the archives.
+	SkipTypes []string
 }
 
 type CloudConn struct {
 	*blob.Bucket
 	remoteId int
 	closed   bool
+	config   *ConnectionConfig
+	pooled   *pooledBucket
+}
+
+// Close releases the connection. Pooled buckets are handed back to their
+// pool instead of being closed.
+func (conn *CloudConn) Close() error {
+	if conn.closed {
+		return nil
+	}
+	conn.closed = true
+	if conn.pooled != nil {
+		return conn.pooled.release()
+	}
+	return conn.Bucket.Close()
+}
+
+// Config returns the configuration the connection was opened with.
+func (conn *CloudConn) Config() *ConnectionConfig {
+	return conn.config
+}
+
+// Open opens a connection to the backend of the remote.
+func (config *ConnectionConfig) Open(ctx context.Context) (CloudConn, error) {
+	switch config.Type {
+	case ConfigTypeAzure:
+		return config.OpenAzure(ctx)
+	case ConfigTypeHttp:
+		return config.OpenHttp(ctx)
+	case ConfigTypeS3:
+		return config.OpenS3(ctx)
+	case ConfigTypeGCS:
+		return config.OpenGCS(ctx)
+	case ConfigTypeMemory:
+		return config.OpenMemory(ctx)
+	default:
+		return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot create connections for remote type %q", config.Type)
+	}
+}
+
+// prefixed scopes the bucket under the configured prefix, if any.
+func (config *ConnectionConfig) prefixed(b *blob.Bucket) *blob.Bucket {
+	if config.Prefix == "" {
+		return b
+	}
+	return blob.PrefixedBucket(b, strings.TrimSuffix(config.Prefix, "/")+"/")
 }
 
 func (config *ConnectionConfig) OpenHttp(ctx context.Context) (CloudConn, error) {
@@ -53,9 +178,9 @@ func (config *ConnectionConfig) OpenHttp(ctx context.Context) (CloudConn, error)
 
 	b, errOpen := fileblob.OpenBucket(config.ContainerName, &fileblob.Options{CreateDir: true})
 	if errOpen != nil {
-		return CloudConn{nil, 0, true}, fmt.Errorf("Cannot open Bucket, %w", errOpen)
+		return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot open Bucket, %w", errOpen)
 	}
-	return CloudConn{b, config.RemoteId, false}, nil
+	return CloudConn{config.prefixed(b), config.RemoteId, false, config, nil}, nil
 }
 
 func (config *ConnectionConfig) OpenAzure(ctx context.Context) (CloudConn, error) {
@@ -65,7 +190,7 @@ func (config *ConnectionConfig) OpenAzure(ctx context.Context) (CloudConn, error
 
 	credential, err := azureblob.NewCredential(accountName, accountKey)
 	if err != nil {
-		return CloudConn{nil, 0, true}, fmt.Errorf("Cannot create Azure credentials, %w", err)
+		return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot create Azure credentials, %w", err)
 	}
 
 	po := azblob.PipelineOptions{
@@ -101,26 +226,10 @@ func (config *ConnectionConfig) OpenAzure(ctx context.Context) (CloudConn, error
 		// Set HTTPSender to override the default HTTP Sender that sends the request over the network
 		HTTPSender: pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
 			return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
-				// Implement the HTTP client that will override the default sender.
-				// For example, below HTTP client uses a transport that is different from http.DefaultTransport
-				client := http.Client{
-					Transport: &http.Transport{
-						Proxy: nil,
-						DialContext: (&net.Dialer{
-							Timeout:   1 * time.Hour,
-							KeepAlive: 1 * time.Hour,
-							DualStack: true,
-						}).DialContext,
-						MaxIdleConns:          1000,
-						MaxConnsPerHost:       200,
-						IdleConnTimeout:       60 * time.Minute,
-						TLSHandshakeTimeout:   60 * time.Minute,
-						ExpectContinueTimeout: 60 * time.Minute,
-					},
-				}
-
-				// Send the request over the network
-				resp, err := client.Do(request.WithContext(context.Background()))
+				// Send the request over the network, all the buckets share the
+				// same client so their connections are reused. The context is
+				// the one of the operation, so an aborted request stops it.
+				resp, err := azureClient.Do(request.WithContext(ctx))
 
 				return pipeline.NewHTTPResponse(resp), err
 			}
@@ -129,7 +238,7 @@ func (config *ConnectionConfig) OpenAzure(ctx context.Context) (CloudConn, error
 	pipeline := azureblob.NewPipeline(credential, po)
 	b, errOpen := azureblob.OpenBucket(ctx, pipeline, accountName, containerName, &azureblob.Options{Credential: credential})
 	if errOpen != nil {
-		return CloudConn{nil, 0, true}, fmt.Errorf("Cannot create Azure credentials, %w", err)
+		return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot open Azure bucket, %w", errOpen)
 	}
-	return CloudConn{b, config.RemoteId, false}, nil
+	return CloudConn{config.prefixed(b), config.RemoteId, false, config, nil}, nil
 }
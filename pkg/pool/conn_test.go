@@ -0,0 +1,61 @@
+package pool
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc answers the requests of a client without the network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestAzureRequestContext(t *testing.T) {
+	sent := make(chan struct{}, 1)
+	cancelled := make(chan bool, 1)
+	client := azureClient
+	t.Cleanup(func() { azureClient = client })
+	azureClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			cancelled <- true
+			return nil, r.Context().Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- false
+			return &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+		}
+	})}
+
+	config := &ConnectionConfig{
+		Type:          ConfigTypeAzure,
+		AccountName:   "account",
+		AccountKey:    base64.StdEncoding.EncodeToString([]byte("key")),
+		ContainerName: "datasets",
+	}
+	conn, err := config.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Aborting the operation aborts the request in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sent
+		cancel()
+	}()
+	if _, err := conn.Exists(ctx, "ab/cd"); err == nil {
+		t.Errorf("Exists of a cancelled operation succeeded")
+	}
+	if !<-cancelled {
+		t.Errorf("request not cancelled with its operation")
+	}
+}
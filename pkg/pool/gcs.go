@@ -0,0 +1,117 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"gocloud.dev/blob/gcsblob"
+	"gocloud.dev/gcp"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsScope reads and writes objects, the proxy never changes bucket ACLs.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCSConfig holds the settings of Google Cloud Storage remotes.
+type GCSConfig struct {
+	// CredentialsFile and CredentialsJSON hold a service account key, the
+	// application default credentials are used when both are empty.
+	CredentialsFile string
+	CredentialsJSON string
+
+	// EmulatorHost sends the requests of this remote to a GCS emulator such
+	// as fake-gcs-server, like STORAGE_EMULATOR_HOST does for every remote.
+	EmulatorHost string
+}
+
+func (config *ConnectionConfig) OpenGCS(ctx context.Context) (CloudConn, error) {
+	var client *gcp.HTTPClient
+	opts := &gcsblob.Options{}
+
+	if config.GCS.EmulatorHost != "" {
+		transport, err := newEmulatorTransport(config.GCS.EmulatorHost, backendClient.Transport)
+		if err != nil {
+			return CloudConn{nil, 0, true, config, nil}, err
+		}
+		client = gcp.NewAnonymousHTTPClient(transport)
+	} else {
+		credentialsJSON := []byte(config.GCS.CredentialsJSON)
+		if config.GCS.CredentialsFile != "" {
+			content, err := os.ReadFile(config.GCS.CredentialsFile)
+			if err != nil {
+				return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot read GCS credentials, %w", err)
+			}
+			credentialsJSON = content
+		}
+
+		var creds *google.Credentials
+		var err error
+		if len(credentialsJSON) > 0 {
+			creds, err = google.CredentialsFromJSON(ctx, credentialsJSON, gcsScope)
+			opts.GoogleAccessID, opts.PrivateKey = serviceAccountKey(credentialsJSON)
+		} else {
+			creds, err = gcp.DefaultCredentials(ctx)
+		}
+		if err != nil {
+			return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot create GCS credentials, %w", err)
+		}
+
+		client, err = gcp.NewHTTPClient(backendClient.Transport, gcp.CredentialsTokenSource(creds))
+		if err != nil {
+			return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot create GCS client, %w", err)
+		}
+	}
+
+	b, errOpen := gcsblob.OpenBucket(ctx, client, config.ContainerName, opts)
+	if errOpen != nil {
+		return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot open GCS bucket, %w", errOpen)
+	}
+	return CloudConn{config.prefixed(b), config.RemoteId, false, config, nil}, nil
+}
+
+// serviceAccountKey extracts the identity used to sign URLs from a service
+// account key file.
+func serviceAccountKey(credentialsJSON []byte) (string, []byte) {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(credentialsJSON, &key); err != nil {
+		return "", nil
+	}
+	return key.ClientEmail, []byte(key.PrivateKey)
+}
+
+// emulatorTransport redirects the requests meant for Google to an emulator.
+type emulatorTransport struct {
+	scheme string
+	host   string
+	base   http.RoundTripper
+}
+
+// newEmulatorTransport parses the address of an emulator, either a URL or a
+// bare host:port reached over plain HTTP like STORAGE_EMULATOR_HOST.
+func newEmulatorTransport(emulatorHost string, base http.RoundTripper) (*emulatorTransport, error) {
+	address := emulatorHost
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid GCS emulator host %q", emulatorHost)
+	}
+	return &emulatorTransport{scheme: u.Scheme, host: u.Host, base: base}, nil
+}
+
+func (t *emulatorTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.scheme
+	r.URL.Host = t.host
+	r.Host = t.host
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,114 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGCS answers the object metadata requests of the JSON API for the
+// objects it holds, recording the requests it receives.
+type fakeGCS struct {
+	mu       sync.Mutex
+	objects  map[string]string
+	requests []*http.Request
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+
+	const prefix = "/storage/v1/b/datasets/o/"
+	name := strings.TrimPrefix(r.URL.Path, prefix)
+	content, ok := f.objects[name]
+	if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, prefix) || !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "Not Found"}})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"kind":        "storage#object",
+		"bucket":      "datasets",
+		"name":        name,
+		"size":        "7",
+		"contentType": content,
+		"updated":     time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (f *fakeGCS) last() *http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[len(f.requests)-1]
+}
+
+func TestOpenGCSEmulator(t *testing.T) {
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	fake := &fakeGCS{objects: map[string]string{"team/ab/cd": "text/csv"}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	for _, emulatorHost := range []string{server.URL, strings.TrimPrefix(server.URL, "http://")} {
+		config := &ConnectionConfig{
+			Type:          ConfigTypeGCS,
+			ContainerName: "datasets",
+			Prefix:        "team/",
+			GCS:           GCSConfig{EmulatorHost: emulatorHost},
+		}
+		conn, err := config.Open(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		attrs, err := conn.Attributes(context.Background(), "ab/cd")
+		if err != nil {
+			t.Fatalf("%s: %v", emulatorHost, err)
+		}
+		if attrs.Size != 7 || attrs.ContentType != "text/csv" {
+			t.Errorf("%s: attributes %+v", emulatorHost, attrs)
+		}
+		if r := fake.last(); r.Host != strings.TrimPrefix(server.URL, "http://") || r.Header.Get("Authorization") != "" {
+			t.Errorf("%s: request to %s with Authorization %q", emulatorHost, r.Host, r.Header.Get("Authorization"))
+		}
+		if exists, err := conn.Exists(context.Background(), "ef/gh"); err != nil || exists {
+			t.Errorf("%s: missing object exists %v, %v", emulatorHost, exists, err)
+		}
+	}
+}
+
+func TestNewEmulatorTransport(t *testing.T) {
+	tests := []struct {
+		emulatorHost string
+		scheme, host string
+	}{
+		{"localhost:4443", "http", "localhost:4443"},
+		{"http://localhost:4443", "http", "localhost:4443"},
+		{"https://gcs.test", "https", "gcs.test"},
+		{"http://localhost:4443/storage/v1/", "http", "localhost:4443"},
+	}
+	for _, test := range tests {
+		transport, err := newEmulatorTransport(test.emulatorHost, http.DefaultTransport)
+		if err != nil {
+			t.Errorf("%s: %v", test.emulatorHost, err)
+			continue
+		}
+		if transport.scheme != test.scheme || transport.host != test.host {
+			t.Errorf("%s: %s://%s, want %s://%s", test.emulatorHost, transport.scheme, transport.host, test.scheme, test.host)
+		}
+	}
+
+	for _, emulatorHost := range []string{"ftp://localhost:21", "http://", "http://[::1"} {
+		if _, err := newEmulatorTransport(emulatorHost, http.DefaultTransport); err == nil {
+			t.Errorf("%s: accepted", emulatorHost)
+		}
+	}
+}
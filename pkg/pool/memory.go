@@ -0,0 +1,288 @@
+package pool
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+// MemoryConfig holds the settings of in-memory remotes.
+type MemoryConfig struct {
+	// MaxSize bounds the bytes held by the remote, the least recently used
+	// objects are evicted to make room for new ones. Zero means unbounded.
+	MaxSize int64
+}
+
+// errMemoryFull is returned when an object alone does not fit in the remote.
+var errMemoryFull = errors.New("object is larger than the memory remote")
+
+// OpenMemory opens an empty bucket living in the memory of the process. Its
+// content is lost when the bucket is closed.
+func (config *ConnectionConfig) OpenMemory(ctx context.Context) (CloudConn, error) {
+	b := memblob.OpenBucket(nil)
+	if config.Memory.MaxSize > 0 {
+		b = blob.NewBucket(newLRUBucket(b, config.Memory.MaxSize))
+	}
+	return CloudConn{config.prefixed(b), config.RemoteId, false, config, nil}, nil
+}
+
+// lruBucket is a driver wrapping a bucket to keep its size under maxSize,
+// evicting the objects read or written the longest time ago.
+type lruBucket struct {
+	bucket  *blob.Bucket
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key  string
+	size int64
+}
+
+func newLRUBucket(bucket *blob.Bucket, maxSize int64) *lruBucket {
+	return &lruBucket{
+		bucket:  bucket,
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (b *lruBucket) touch(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[key]; ok {
+		b.order.MoveToFront(e)
+	}
+}
+
+// forget drops key from the recency list, b.mu must be held.
+func (b *lruBucket) forget(key string) {
+	if e, ok := b.entries[key]; ok {
+		b.size -= e.Value.(*lruEntry).size
+		b.order.Remove(e)
+		delete(b.entries, key)
+	}
+}
+
+// tooLarge returns the error of an object that alone does not fit in maxSize.
+func (b *lruBucket) tooLarge(size int64) error {
+	return fmt.Errorf("%w: %d bytes over %d", errMemoryFull, size, b.maxSize)
+}
+
+// add records a written object and evicts the oldest ones until the bucket
+// fits in maxSize again. b.mu must be held, so that the list and the bucket
+// stay consistent while concurrent writers commit.
+func (b *lruBucket) add(key string, size int64) {
+	b.forget(key)
+	b.entries[key] = b.order.PushFront(&lruEntry{key, size})
+	b.size += size
+
+	for b.size > b.maxSize {
+		e := b.order.Back()
+		entry := e.Value.(*lruEntry)
+		log.
+			WithField("key", entry.key).
+			Debug("Evicting object from memory remote")
+		if err := b.bucket.Delete(context.Background(), entry.key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			log.
+				WithField("key", entry.key).
+				WithError(err).
+				Warn("Cannot evict object from memory remote")
+		}
+		b.forget(entry.key)
+	}
+}
+
+func (b *lruBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if errors.Is(err, errMemoryFull) {
+		return gcerrors.ResourceExhausted
+	}
+	return gcerrors.Code(err)
+}
+
+func (b *lruBucket) As(i interface{}) bool { return false }
+
+func (b *lruBucket) ErrorAs(err error, i interface{}) bool { return false }
+
+func (b *lruBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	attrs, err := b.bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Attributes{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+		CreateTime:         attrs.CreateTime,
+		ModTime:            attrs.ModTime,
+		Size:               attrs.Size,
+		MD5:                attrs.MD5,
+		ETag:               attrs.ETag,
+	}, nil
+}
+
+func (b *lruBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	// Drivers get an empty token and no size for the first and default
+	// page, ListPage wants FirstPageToken and a size.
+	token, pageSize := opts.PageToken, opts.PageSize
+	if len(token) == 0 {
+		token = blob.FirstPageToken
+	}
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+	objects, next, err := b.bucket.ListPage(ctx, token, pageSize, &blob.ListOptions{
+		Prefix:    opts.Prefix,
+		Delimiter: opts.Delimiter,
+	})
+	if err == io.EOF {
+		return &driver.ListPage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	page := &driver.ListPage{NextPageToken: next}
+	for _, object := range objects {
+		page.Objects = append(page.Objects, &driver.ListObject{
+			Key:     object.Key,
+			ModTime: object.ModTime,
+			Size:    object.Size,
+			MD5:     object.MD5,
+			IsDir:   object.IsDir,
+		})
+	}
+	return page, nil
+}
+
+func (b *lruBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	reader, err := b.bucket.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		return nil, err
+	}
+	b.touch(key)
+	return &lruReader{reader}, nil
+}
+
+func (b *lruBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	// Cancelling the context aborts the write of an object too large to fit
+	ctx, cancel := context.WithCancel(ctx)
+	writer, err := b.bucket.NewWriter(ctx, key, &blob.WriterOptions{
+		BufferSize:         opts.BufferSize,
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        contentType,
+		ContentMD5:         opts.ContentMD5,
+		Metadata:           opts.Metadata,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &lruWriter{bucket: b, key: key, writer: writer, cancel: cancel}, nil
+}
+
+func (b *lruBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.bucket.Copy(ctx, dstKey, srcKey, nil); err != nil {
+		return err
+	}
+	attrs, err := b.bucket.Attributes(ctx, dstKey)
+	if err != nil {
+		return err
+	}
+	b.add(dstKey, attrs.Size)
+	return nil
+}
+
+func (b *lruBucket) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.bucket.Delete(ctx, key); err != nil {
+		return err
+	}
+	b.forget(key)
+	return nil
+}
+
+func (b *lruBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return b.bucket.SignedURL(ctx, key, &blob.SignedURLOptions{
+		Expiry: opts.Expiry,
+		Method: opts.Method,
+	})
+}
+
+func (b *lruBucket) Close() error {
+	return b.bucket.Close()
+}
+
+type lruReader struct {
+	*blob.Reader
+}
+
+func (r *lruReader) Attributes() *driver.ReaderAttributes {
+	return &driver.ReaderAttributes{
+		ContentType: r.ContentType(),
+		ModTime:     r.ModTime(),
+		Size:        r.Size(),
+	}
+}
+
+func (r *lruReader) As(i interface{}) bool { return false }
+
+type lruWriter struct {
+	bucket *lruBucket
+	key    string
+	writer *blob.Writer
+	cancel context.CancelFunc
+	size   int64
+	err    error
+}
+
+func (w *lruWriter) Write(p []byte) (int, error) {
+	// Refuse the object before buffering more than the remote can hold
+	if w.size+int64(len(p)) > w.bucket.maxSize {
+		w.err = w.bucket.tooLarge(w.size + int64(len(p)))
+		return 0, w.err
+	}
+	n, err := w.writer.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *lruWriter) Close() error {
+	defer w.cancel()
+	if w.err != nil {
+		// Abort the write, any previous version of the key is left untouched
+		w.cancel()
+		_ = w.writer.Close()
+		return w.err
+	}
+
+	w.bucket.mu.Lock()
+	defer w.bucket.mu.Unlock()
+	if err := w.writer.Close(); err != nil {
+		return err
+	}
+	w.bucket.add(w.key, w.size)
+	return nil
+}
@@ -0,0 +1,67 @@
+package pool
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestMemoryEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	config := &ConnectionConfig{Type: ConfigTypeMemory, Memory: MemoryConfig{MaxSize: 10}}
+	conn, err := config.Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, key := range []string{"a", "b"} {
+		if err := conn.WriteAll(ctx, key, []byte("12345"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading a makes b the least recently used
+	if _, err := conn.ReadAll(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteAll(ctx, "c", []byte("12345"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := listKeys(t, conn.Bucket); len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("listed %q, want a and c", keys)
+	}
+
+	// An object larger than the remote is refused without evicting the others
+	err = conn.WriteAll(ctx, "d", []byte("0123456789a"), nil)
+	if gcerrors.Code(err) != gcerrors.ResourceExhausted {
+		t.Errorf("writing an object larger than the remote returned %v", err)
+	}
+	if keys := listKeys(t, conn.Bucket); len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("listed %q after an oversized write, want a and c", keys)
+	}
+	for _, key := range []string{"a", "c"} {
+		if content, err := conn.ReadAll(ctx, key); err != nil || string(content) != "12345" {
+			t.Errorf("reading %s after an oversized write returned %q, %v", key, content, err)
+		}
+	}
+}
+
+func listKeys(t *testing.T, bucket *blob.Bucket) []string {
+	t.Helper()
+	var keys []string
+	iter := bucket.List(&blob.ListOptions{})
+	for {
+		object, err := iter.Next(context.Background())
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, object.Key)
+	}
+}
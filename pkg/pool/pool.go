@@ -0,0 +1,228 @@
+package pool
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+)
+
+// stats are published under "pool" in /debug/vars.
+var stats = expvar.NewMap("pool")
+
+// Stats counts how the pool answered the connection requests.
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Open      int   `json:"open"`
+}
+
+// Pool keeps the opened buckets of each remote so that consecutive requests
+// reuse the same pipeline and its connections. Buckets not used for ttl are
+// evicted and closed once the last request using them is done, except the
+// memory ones.
+type Pool struct {
+	cache *cache.Cache
+	ttl   time.Duration
+	done  chan struct{}
+
+	// mu serializes opening buckets and evicting them.
+	mu                      sync.Mutex
+	hits, misses, evictions int64
+	closeOnce               sync.Once
+}
+
+func NewPool(ttl time.Duration) *Pool {
+	p := &Pool{
+		// Entries expire on their own lastUsed, checked by janitor.
+		cache: cache.New(cache.NoExpiration, 0),
+		ttl:   ttl,
+		done:  make(chan struct{}),
+	}
+	// Entries are only deleted holding mu
+	p.cache.OnEvicted(func(key string, value interface{}) {
+		p.evictions++
+		stats.Add("evictions", 1)
+
+		log.
+			WithField("key", key).
+			Debug("Evicting connection")
+		value.(*pooledBucket).evict()
+	})
+	if ttl > 0 {
+		go p.janitor(ttl / 2)
+	}
+	return p
+}
+
+// Get returns a connection to the remote of config. Closing it hands the
+// bucket back to the pool.
+func (p *Pool) Get(ctx context.Context, config *ConnectionConfig) (*CloudConn, error) {
+	key := config.poolKey()
+
+	// acquire refreshes the sliding expiration, the bucket stays while it
+	// is used.
+	if value, ok := p.cache.Get(key); ok {
+		entry := value.(*pooledBucket)
+		if entry.acquire() {
+			p.hit()
+			return &CloudConn{entry.bucket, config.RemoteId, false, config, entry}, nil
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Another request may have opened it while we were waiting for the lock.
+	if value, ok := p.cache.Get(key); ok {
+		entry := value.(*pooledBucket)
+		if entry.acquire() {
+			p.hits++
+			stats.Add("hits", 1)
+			return &CloudConn{entry.bucket, config.RemoteId, false, config, entry}, nil
+		}
+	}
+
+	// Pooled buckets outlive the request that opened them.
+	conn, err := config.Open(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	p.misses++
+	stats.Add("misses", 1)
+
+	entry := &pooledBucket{bucket: conn.Bucket, keep: config.Type == ConfigTypeMemory}
+	entry.acquire()
+	p.cache.Set(key, entry, cache.NoExpiration)
+	return &CloudConn{entry.bucket, config.RemoteId, false, config, entry}, nil
+}
+
+// janitor evicts the buckets unused for ttl, every interval until the pool is
+// closed.
+func (p *Pool) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.evictExpired(time.Now())
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// evictExpired evicts the buckets last used before now minus ttl. Holding mu,
+// no bucket of the same remote is opened meanwhile.
+func (p *Pool) evictExpired(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, item := range p.cache.Items() {
+		// expire marks the bucket evicted, so that no request acquires it
+		// between the check and the deletion.
+		if item.Object.(*pooledBucket).expire(now.Add(-p.ttl)) {
+			p.cache.Delete(key)
+		}
+	}
+}
+
+func (p *Pool) hit() {
+	p.mu.Lock()
+	p.hits++
+	p.mu.Unlock()
+	stats.Add("hits", 1)
+}
+
+// Stats returns the pool counters since it was created.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{
+		Hits:      p.hits,
+		Misses:    p.misses,
+		Evictions: p.evictions,
+		Open:      p.cache.ItemCount(),
+	}
+}
+
+// Close stops the janitor and evicts every bucket of the pool.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.cache.Items() {
+		p.cache.Delete(key)
+	}
+}
+
+// pooledBucket counts the requests using a bucket so that eviction does not
+// close it under their feet.
+type pooledBucket struct {
+	bucket *blob.Bucket
+	// keep is set for the memory buckets, which are never evicted.
+	keep bool
+
+	mu       sync.Mutex
+	refs     int
+	lastUsed time.Time
+	evicted  bool
+}
+
+func (b *pooledBucket) acquire() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.evicted {
+		return false
+	}
+	b.refs++
+	b.lastUsed = time.Now()
+	return true
+}
+
+func (b *pooledBucket) release() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refs--
+	b.lastUsed = time.Now()
+	if b.evicted && b.refs == 0 {
+		return b.bucket.Close()
+	}
+	return nil
+}
+
+// expire marks the bucket evicted when it is not in use and was last used
+// before deadline.
+func (b *pooledBucket) expire(deadline time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keep || b.evicted || b.refs > 0 || b.lastUsed.After(deadline) {
+		return false
+	}
+	b.evicted = true
+	return true
+}
+
+// evict closes the bucket once it is removed from the pool, or lets the last
+// request using it do so.
+func (b *pooledBucket) evict() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.evicted = true
+	if b.refs == 0 {
+		if err := b.bucket.Close(); err != nil {
+			log.
+				WithError(err).
+				Warn("Cannot close evicted bucket")
+		}
+	}
+}
+
+// poolKey identifies the bucket opened for config.
+func (config *ConnectionConfig) poolKey() string {
+	return fmt.Sprintf("%s|%s|%d|%s|%s|%s", config.Type, config.Name, config.RemoteId, config.AccountName, config.ContainerName, config.Prefix)
+}
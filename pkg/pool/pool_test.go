@@ -0,0 +1,124 @@
+package pool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func folderConfig(t *testing.T) *ConnectionConfig {
+	return &ConnectionConfig{Name: "local", Type: ConfigTypeHttp, ContainerName: t.TempDir()}
+}
+
+// usable reports whether the bucket of conn is still open.
+func usable(conn *CloudConn) bool {
+	_, err := conn.Exists(context.Background(), "ab/cd")
+	return err == nil
+}
+
+func TestPoolReusesBuckets(t *testing.T) {
+	p := NewPool(0)
+	defer p.Close()
+	config := folderConfig(t)
+
+	first, err := p.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	second, err := p.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if first.Bucket != second.Bucket {
+		t.Error("the bucket was opened twice")
+	}
+	if stats := p.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Open != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestPoolEvictsUnusedBuckets(t *testing.T) {
+	p := NewPool(0)
+	p.ttl = time.Minute
+	defer p.Close()
+	config := folderConfig(t)
+
+	conn, err := p.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In use, the bucket is kept whatever its age
+	p.evictExpired(time.Now().Add(time.Hour))
+	if !usable(conn) || p.Stats().Open != 1 {
+		t.Fatal("a bucket in use was evicted")
+	}
+	conn.Close()
+
+	p.evictExpired(time.Now())
+	if p.Stats().Open != 1 {
+		t.Fatal("a bucket used within the ttl was evicted")
+	}
+	p.evictExpired(time.Now().Add(time.Hour))
+	if stats := p.Stats(); stats.Open != 0 || stats.Evictions != 1 {
+		t.Fatalf("stats %+v, want the bucket evicted", stats)
+	}
+	if usable(conn) {
+		t.Error("the evicted bucket is still open")
+	}
+
+	reopened, err := p.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if !usable(reopened) {
+		t.Error("the bucket opened after the eviction is closed")
+	}
+}
+
+func TestPoolKeepsMemoryBuckets(t *testing.T) {
+	p := NewPool(0)
+	p.ttl = time.Minute
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), &ConnectionConfig{Name: "scratch", Type: ConfigTypeMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	p.evictExpired(time.Now().Add(time.Hour))
+	if p.Stats().Open != 1 {
+		t.Error("a memory bucket was evicted")
+	}
+}
+
+// TestPoolConcurrentEviction runs requests while the janitor evicts, every
+// connection handed out must stay usable until it is closed.
+func TestPoolConcurrentEviction(t *testing.T) {
+	p := NewPool(time.Millisecond)
+	defer p.Close()
+	config := folderConfig(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				conn, err := p.Get(context.Background(), config)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !usable(conn) {
+					t.Error("got a closed bucket")
+				}
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,76 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"gocloud.dev/blob/s3blob"
+)
+
+// backendClient is shared by the S3 buckets so their connections are reused.
+var backendClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          1000,
+		MaxIdleConnsPerHost:   200,
+		MaxConnsPerHost:       200,
+		IdleConnTimeout:       60 * time.Minute,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+}
+
+// S3Config holds the settings of S3 compatible remotes.
+type S3Config struct {
+	// Endpoint overrides the AWS endpoint, e.g. http://minio:9000.
+	Endpoint string
+	Region   string
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// as most S3 compatible servers expect.
+	PathStyle bool
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func (config *ConnectionConfig) OpenS3(ctx context.Context) (CloudConn, error) {
+	awsConfig := &aws.Config{
+		HTTPClient:       backendClient,
+		S3ForcePathStyle: aws.Bool(config.S3.PathStyle),
+	}
+	if config.S3.Region != "" {
+		awsConfig.Region = aws.String(config.S3.Region)
+	} else {
+		// The region is required by the SDK even if the endpoint ignores it.
+		awsConfig.Region = aws.String("us-east-1")
+	}
+	if config.S3.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.S3.Endpoint)
+	}
+	if config.S3.AccessKeyID != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(config.S3.AccessKeyID, config.S3.SecretAccessKey, config.S3.SessionToken)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot create S3 session, %w", err)
+	}
+
+	b, errOpen := s3blob.OpenBucket(ctx, sess, config.ContainerName, nil)
+	if errOpen != nil {
+		return CloudConn{nil, 0, true, config, nil}, fmt.Errorf("Cannot open S3 bucket, %w", errOpen)
+	}
+	return CloudConn{config.prefixed(b), config.RemoteId, false, config, nil}, nil
+}
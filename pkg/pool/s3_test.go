@@ -0,0 +1,190 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 stores the objects of PUT requests and serves them back, recording
+// the requests it receives.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	requests []*http.Request
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+	key := r.Host + r.URL.Path
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		body, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// last returns the last request received.
+func (f *fakeS3) last() *http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[len(f.requests)-1]
+}
+
+// startFakeS3 serves a fakeS3 and makes backendClient dial it, whatever the
+// host name, so that virtual hosted buckets reach it too.
+func startFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := backendClient
+	t.Cleanup(func() { backendClient = client })
+	backendClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}}
+
+	// No credentials or region from the machine running the tests
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return fake, server
+}
+
+func TestOpenS3(t *testing.T) {
+	tests := []struct {
+		name   string
+		config S3Config
+		prefix string
+		// host and path expected for the object ab/cd
+		host, path string
+		// credential is the start of the Credential of the signature
+		credential string
+		token      string
+	}{
+		{
+			name: "path style",
+			config: S3Config{
+				Region: "eu-west-3", PathStyle: true,
+				AccessKeyID: "AKIDPATH", SecretAccessKey: "secret",
+			},
+			host:       "{server}",
+			path:       "/datasets/ab/cd",
+			credential: "AKIDPATH/{date}/eu-west-3/s3/aws4_request",
+		},
+		{
+			name: "virtual hosted",
+			config: S3Config{
+				Region:      "eu-central-1",
+				AccessKeyID: "AKIDHOST", SecretAccessKey: "secret",
+			},
+			host:       "datasets.{server}",
+			path:       "/ab/cd",
+			credential: "AKIDHOST/{date}/eu-central-1/s3/aws4_request",
+		},
+		{
+			name: "prefix and session token",
+			config: S3Config{
+				PathStyle:   true,
+				AccessKeyID: "AKIDTEMP", SecretAccessKey: "secret", SessionToken: "session",
+			},
+			prefix:     "team/",
+			host:       "{server}",
+			path:       "/datasets/team/ab/cd",
+			credential: "AKIDTEMP/{date}/us-east-1/s3/aws4_request",
+			token:      "session",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, server := startFakeS3(t)
+			serverHost := strings.TrimPrefix(server.URL, "http://")
+			test.config.Endpoint = "http://" + strings.Replace(serverHost, "127.0.0.1", "s3.test", 1)
+			host := strings.Replace(test.host, "{server}", strings.TrimPrefix(test.config.Endpoint, "http://"), 1)
+
+			config := &ConnectionConfig{Type: ConfigTypeS3, ContainerName: "datasets", Prefix: test.prefix, S3: test.config}
+			conn, err := config.Open(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			ctx := context.Background()
+			if err := conn.WriteAll(ctx, "ab/cd", []byte("content"), nil); err != nil {
+				t.Fatal(err)
+			}
+			put := fake.last()
+			if put.Host != host || put.URL.Path != test.path {
+				t.Errorf("PUT %s%s, want %s%s", put.Host, put.URL.Path, host, test.path)
+			}
+			credential := strings.Replace(test.credential, "{date}", time.Now().UTC().Format("20060102"), 1)
+			if authorization := put.Header.Get("Authorization"); !strings.Contains(authorization, "Credential="+credential+",") {
+				t.Errorf("Authorization %q, want the credential %s", authorization, credential)
+			}
+			if got := put.Header.Get("X-Amz-Security-Token"); got != test.token {
+				t.Errorf("X-Amz-Security-Token %q, want %q", got, test.token)
+			}
+
+			content, err := conn.ReadAll(ctx, "ab/cd")
+			if err != nil || string(content) != "content" {
+				t.Errorf("read %q, %v", content, err)
+			}
+			if exists, err := conn.Exists(ctx, "ef/gh"); err != nil || exists {
+				t.Errorf("missing object exists %v, %v", exists, err)
+			}
+		})
+	}
+}
+
+func TestOpenS3EnvironmentCredentials(t *testing.T) {
+	fake, server := startFakeS3(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	config := &ConnectionConfig{Type: ConfigTypeS3, ContainerName: "datasets", S3: S3Config{Endpoint: server.URL, PathStyle: true}}
+	conn, err := config.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteAll(context.Background(), "ab/cd", []byte("content"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if authorization := fake.last().Header.Get("Authorization"); !strings.Contains(authorization, "Credential=AKIDENV/") {
+		t.Errorf("Authorization %q, want the credentials of the environment", authorization)
+	}
+}
@@ -0,0 +1,123 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/gcerrors"
+)
+
+// apiError is an error of the S3 API, written as an <Error> document.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+var (
+	errAccessDenied          = &apiError{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errInvalidAccessKey      = &apiError{http.StatusForbidden, "InvalidAccessKeyId", "The access key does not exist"}
+	errSignature             = &apiError{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match"}
+	errSkewed                = &apiError{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server time is too large"}
+	errExpired               = &apiError{http.StatusForbidden, "AccessDenied", "Request has expired"}
+	errContentSHA256         = &apiError{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 does not match the payload"}
+	errMalformedChunk        = &apiError{http.StatusBadRequest, "IncompleteBody", "The chunked payload is malformed"}
+	errIncompleteBody        = &apiError{http.StatusBadRequest, "IncompleteBody", "The body does not match the declared length"}
+	errMissingContentLength  = &apiError{http.StatusLengthRequired, "MissingContentLength", "Content-Length is required"}
+	errInvalidDigest         = &apiError{http.StatusBadRequest, "InvalidDigest", "The Content-MD5 is not valid"}
+	errBadDigest             = &apiError{http.StatusBadRequest, "BadDigest", "The content does not match its MD5"}
+	errInvalidArgument       = &apiError{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	errMalformedXML          = &apiError{http.StatusBadRequest, "MalformedXML", "The XML is not well-formed"}
+	errNoSuchBucket          = &apiError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errNoSuchKey             = &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	errNoSuchUpload          = &apiError{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist"}
+	errInvalidPart           = &apiError{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"}
+	errInvalidPartOrder      = &apiError{http.StatusBadRequest, "InvalidPartOrder", "The parts must be listed in ascending order"}
+	errInsufficientStorage   = &apiError{http.StatusInsufficientStorage, "InsufficientStorage", "The remote has no room for the object"}
+	errNotImplemented        = &apiError{http.StatusNotImplemented, "NotImplemented", "This operation is not supported"}
+	errMethodNotAllowed      = &apiError{http.StatusMethodNotAllowed, "MethodNotAllowed", "The method is not allowed against this resource"}
+	errInternal              = &apiError{http.StatusInternalServerError, "InternalError", "We encountered an internal error, please try again"}
+	errServiceUnavailable    = &apiError{http.StatusServiceUnavailable, "ServiceUnavailable", "Cannot reach the remote"}
+	errBucketConfigNotLoaded = &apiError{http.StatusForbidden, "AccessDenied", "Cannot load the configuration of the bucket"}
+)
+
+// blobError translates the errors of the blob package to S3 errors.
+func blobError(err error) error {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		return errNoSuchKey
+	case gcerrors.FailedPrecondition:
+		// A ContentMD5 the written bytes do not match
+		return errBadDigest
+	case gcerrors.InvalidArgument:
+		return errInvalidArgument
+	case gcerrors.PermissionDenied:
+		return errAccessDenied
+	case gcerrors.ResourceExhausted:
+		return errInsufficientStorage
+	}
+	return err
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// writeError writes err as an S3 <Error> document. Errors that are not
+// errors of the API become an InternalError.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := errInternal
+	message := apiErr.message
+	if errors.As(blobError(err), &apiErr) {
+		message = blobError(err).Error()
+	}
+
+	entry := log.
+		WithField("method", r.Method).
+		WithField("path", r.URL.Path).
+		WithField("code", apiErr.code).
+		WithError(err)
+	if apiErr.status >= http.StatusInternalServerError {
+		entry.Error("S3 request failed")
+	} else {
+		entry.Warn("S3 request rejected")
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(apiErr.status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	writeXMLBody(w, errorResponse{
+		Code:     apiErr.code,
+		Message:  message,
+		Resource: r.URL.Path,
+	})
+}
+
+// writeXML writes v as the XML body of a 200 response.
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	writeXMLBody(w, v)
+}
+
+func writeXMLBody(w http.ResponseWriter, v interface{}) {
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.
+			WithError(err).
+			Error("Cannot encode S3 response")
+	}
+}
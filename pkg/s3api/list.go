@@ -0,0 +1,178 @@
+package s3api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"gocloud.dev/blob"
+)
+
+const maxKeys = 1000
+
+type locationConstraint struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+	Location string   `xml:",chardata"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner        `xml:"Owner"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listBuckets lists the remotes of the registry. Remotes have no creation
+// date, the time of the request is used.
+func (s *Server) listBuckets(w http.ResponseWriter, req *request) error {
+	result := listAllMyBucketsResult{
+		Owner: owner{ID: req.sig.credential.AccessKeyID, DisplayName: req.sig.credential.AccessKeyID},
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	id := req.sig.credential.identity()
+	for _, config := range s.Remotes.Remotes() {
+		if config.ACL.Check(id, auth.Read, req.Request, bucketName(config)) != nil {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucketInfo{Name: bucketName(config), CreationDate: now})
+	}
+	writeXML(w, result)
+	return nil
+}
+
+// listObjectsV2 lists a page of the bucket. The continuation token is the
+// page token of the backend, so listing a large bucket does not restart from
+// the beginning on each page.
+func (s *Server) listObjectsV2(w http.ResponseWriter, req *request) error {
+	result := listBucketResult{
+		Name:              req.bucket,
+		Prefix:            req.query.Get("prefix"),
+		Delimiter:         req.query.Get("delimiter"),
+		StartAfter:        req.query.Get("start-after"),
+		ContinuationToken: req.query.Get("continuation-token"),
+		EncodingType:      req.query.Get("encoding-type"),
+		MaxKeys:           maxKeys,
+	}
+	if result.EncodingType != "" && result.EncodingType != "url" {
+		return fmt.Errorf("%w: invalid encoding-type %q", errInvalidArgument, result.EncodingType)
+	}
+	if value := req.query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: invalid max-keys %q", errInvalidArgument, value)
+		}
+		if n < maxKeys {
+			result.MaxKeys = n
+		}
+	}
+
+	token := blob.FirstPageToken
+	if result.ContinuationToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return fmt.Errorf("%w: invalid continuation-token", errInvalidArgument)
+		}
+		token = decoded
+	}
+
+	options := &blob.ListOptions{Prefix: result.Prefix, Delimiter: result.Delimiter}
+	config := req.conn.Config()
+	for result.MaxKeys > 0 && token != nil && result.KeyCount == 0 {
+		objects, next, err := req.conn.ListPage(req.Context(), token, result.MaxKeys, options)
+		if err != nil {
+			return blobError(err)
+		}
+		token = next
+
+		for _, obj := range objects {
+			if hidden(config, obj.Key) {
+				continue
+			}
+			// start-after only applies to the first request of a listing
+			if result.ContinuationToken == "" && obj.Key <= result.StartAfter {
+				continue
+			}
+			result.KeyCount++
+			if obj.IsDir {
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{result.encode(obj.Key)})
+				continue
+			}
+			result.Contents = append(result.Contents, object{
+				Key:          result.encode(obj.Key),
+				LastModified: obj.ModTime.UTC().Format(time.RFC3339),
+				ETag:         etag(obj.MD5),
+				Size:         obj.Size,
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	if token != nil && result.MaxKeys > 0 {
+		result.IsTruncated = true
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString(token)
+	}
+
+	result.Prefix = result.encode(result.Prefix)
+	result.Delimiter = result.encode(result.Delimiter)
+	result.StartAfter = result.encode(result.StartAfter)
+	writeXML(w, result)
+	return nil
+}
+
+// encode escapes the keys of the listing when the client asked for an url
+// encoding-type, which lets keys hold characters XML cannot.
+func (result *listBucketResult) encode(key string) string {
+	if result.EncodingType != "url" {
+		return key
+	}
+	return uriEncode(key, false)
+}
+
+// etag is the ETag S3 gives to the objects uploaded in a single part.
+func etag(md5 []byte) string {
+	if len(md5) == 0 {
+		return ""
+	}
+	return `"` + hex.EncodeToString(md5) + `"`
+}
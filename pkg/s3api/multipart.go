@@ -0,0 +1,349 @@
+package s3api
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/bucketfs"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// MultipartPrefix is the folder of a bucket where the parts of a multipart
+// upload are staged, under <MultipartPrefix>/<upload id>/, until they are
+// concatenated when it completes.
+const MultipartPrefix = ".multipart"
+
+const (
+	maxPartNumber   = 10000
+	maxCompleteBody = 1 << 20
+)
+
+// upload is the record of a multipart upload, kept next to its parts.
+type upload struct {
+	Key         string            `json:"key"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func uploadPrefix(uploadID string) string {
+	return path.Join(MultipartPrefix, uploadID) + "/"
+}
+
+func uploadRecordKey(uploadID string) string {
+	return uploadPrefix(uploadID) + "upload.json"
+}
+
+func partKey(uploadID string, partNumber int) string {
+	return fmt.Sprintf("%spart-%05d", uploadPrefix(uploadID), partNumber)
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, req *request) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	record := upload{Key: req.key, ContentType: req.Header.Get("Content-Type")}
+	for name, values := range req.Header {
+		if strings.HasPrefix(name, metaPrefix) {
+			if record.Metadata == nil {
+				record.Metadata = map[string]string{}
+			}
+			record.Metadata[strings.ToLower(strings.TrimPrefix(name, metaPrefix))] = values[0]
+		}
+	}
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := req.conn.WriteAll(req.Context(), uploadRecordKey(uploadID), content, nil); err != nil {
+		return blobError(err)
+	}
+
+	writeXML(w, initiateMultipartUploadResult{
+		Bucket:   req.bucket,
+		Key:      req.key,
+		UploadID: uploadID,
+	})
+	return nil
+}
+
+// loadUpload returns the record of the upload, which must target the key of
+// the request.
+func loadUpload(req *request, uploadID string) (*upload, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil {
+		return nil, errNoSuchUpload
+	}
+	content, err := req.conn.ReadAll(req.Context(), uploadRecordKey(uploadID))
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, errNoSuchUpload
+	}
+	if err != nil {
+		return nil, blobError(err)
+	}
+
+	var record upload
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, err
+	}
+	if record.Key != req.key {
+		return nil, errNoSuchUpload
+	}
+	return &record, nil
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, req *request, uploadID string) error {
+	partNumber, err := strconv.Atoi(req.query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return fmt.Errorf("%w: partNumber must be between 1 and %d", errInvalidArgument, maxPartNumber)
+	}
+	if _, err := loadUpload(req, uploadID); err != nil {
+		return err
+	}
+
+	// The MD5 of the part is kept next to it, as not every backend computes
+	// it for large objects.
+	key := partKey(uploadID, partNumber)
+	sum, err := writeObject(req, key, &blob.WriterOptions{})
+	if err != nil {
+		return err
+	}
+	if err := req.conn.WriteAll(req.Context(), key+".md5", []byte(hex.EncodeToString(sum)), nil); err != nil {
+		return blobError(err)
+	}
+
+	w.Header().Set("ETag", etag(sum))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// completeMultipartUpload concatenates the listed parts into the object.
+// The object is written like a PutObject, so a DVC key whose checksum does
+// not match the parts is rejected and the parts are kept.
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, req *request, uploadID string) error {
+	record, err := loadUpload(req, uploadID)
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.sig.body(req.Request), maxCompleteBody))
+	if err != nil {
+		return blobError(err)
+	}
+	var complete completeMultipartUpload
+	if err := xml.Unmarshal(body, &complete); err != nil || len(complete.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	for i, part := range complete.Parts {
+		if i > 0 && part.PartNumber <= complete.Parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+		sum, err := req.conn.ReadAll(req.Context(), partKey(uploadID, part.PartNumber)+".md5")
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return fmt.Errorf("%w: part %d", errInvalidPart, part.PartNumber)
+		}
+		if err != nil {
+			return blobError(err)
+		}
+		if strings.Trim(part.ETag, `"`) != string(sum) {
+			return fmt.Errorf("%w: ETag of part %d does not match", errInvalidPart, part.PartNumber)
+		}
+	}
+
+	writeCtx, abort := context.WithCancel(req.Context())
+	defer abort()
+	writer, err := req.conn.NewWriter(writeCtx, req.key, &blob.WriterOptions{
+		ContentType: record.ContentType,
+		Metadata:    record.Metadata,
+		ContentMD5:  bucketfs.ContentMD5(req.key),
+	})
+	if err != nil {
+		return blobError(err)
+	}
+
+	hash := md5.New()
+	for _, part := range complete.Parts {
+		if err := copyPart(req, io.MultiWriter(writer, hash), partKey(uploadID, part.PartNumber)); err != nil {
+			abort()
+			writer.Close()
+			return blobError(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return blobError(err)
+	}
+
+	removeUpload(req, uploadID)
+	writeXML(w, completeMultipartUploadResult{
+		Location: req.URL.Path,
+		Bucket:   req.bucket,
+		Key:      req.key,
+		ETag:     etag(hash.Sum(nil)),
+	})
+	return nil
+}
+
+func copyPart(req *request, w io.Writer, key string) error {
+	reader, err := req.conn.NewReader(req.Context(), key, nil)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, req *request, uploadID string) error {
+	if _, err := loadUpload(req, uploadID); err != nil {
+		return err
+	}
+	removeUpload(req, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// removeUpload deletes the record and the parts of the upload. Failures are
+// only logged, the parts left behind do not affect the objects.
+func removeUpload(req *request, uploadID string) {
+	iter := req.conn.List(&blob.ListOptions{Prefix: uploadPrefix(uploadID)})
+	for {
+		obj, err := iter.Next(req.Context())
+		if err == io.EOF {
+			return
+		}
+		if err == nil {
+			err = req.conn.Delete(req.Context(), obj.Key)
+		}
+		if err != nil {
+			log.
+				WithField("bucket", req.bucket).
+				WithField("upload", uploadID).
+				WithError(err).
+				Warn("Cannot remove multipart upload")
+			return
+		}
+	}
+}
+
+// PurgeUploads removes, every interval until ctx is done, the multipart
+// uploads of every remote that received nothing for expiry. Clients that
+// crash or give up never abort their uploads.
+func (s *Server) PurgeUploads(ctx context.Context, interval, expiry time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, config := range s.Remotes.Remotes() {
+				s.purgeRemoteUploads(ctx, config, now.Add(-expiry))
+			}
+		}
+	}
+}
+
+func (s *Server) purgeRemoteUploads(ctx context.Context, config *pool.ConnectionConfig, before time.Time) {
+	conn, err := s.Pool.Get(ctx, config)
+	if err != nil {
+		log.
+			WithField("remote", config.Name).
+			WithField("remoteID", config.RemoteId).
+			WithError(err).
+			Error("Cannot connect to purge the multipart uploads")
+		return
+	}
+	defer conn.Close()
+
+	purged, err := purgeUploads(ctx, conn.Bucket, before)
+	if err != nil {
+		log.
+			WithField("remote", config.Name).
+			WithField("remoteID", config.RemoteId).
+			WithError(err).
+			Error("Cannot purge the multipart uploads")
+	}
+	if purged > 0 {
+		log.
+			WithField("remote", config.Name).
+			WithField("remoteID", config.RemoteId).
+			WithField("purged", purged).
+			Info("Purged abandoned multipart uploads")
+	}
+}
+
+// purgeUploads deletes the record and the parts of the uploads whose last
+// object was written before before, and returns how many were removed.
+func purgeUploads(ctx context.Context, bucket *blob.Bucket, before time.Time) (int, error) {
+	keys := map[string][]string{}
+	lastWrite := map[string]time.Time{}
+	iter := bucket.List(&blob.ListOptions{Prefix: MultipartPrefix + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		uploadID := strings.SplitN(strings.TrimPrefix(obj.Key, MultipartPrefix+"/"), "/", 2)[0]
+		keys[uploadID] = append(keys[uploadID], obj.Key)
+		if obj.ModTime.After(lastWrite[uploadID]) {
+			lastWrite[uploadID] = obj.ModTime
+		}
+	}
+
+	purged := 0
+	for uploadID, uploadKeys := range keys {
+		if !lastWrite[uploadID].Before(before) {
+			continue
+		}
+		for _, key := range uploadKeys {
+			if err := bucket.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+				return purged, err
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
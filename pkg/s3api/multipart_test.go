@@ -0,0 +1,42 @@
+package s3api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob/memblob"
+)
+
+func TestPurgeUploads(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	keys := []string{
+		uploadRecordKey("0a"), partKey("0a", 1), partKey("0a", 2),
+		uploadRecordKey("0b"),
+		"ab/cdef",
+	}
+	for _, key := range keys {
+		if err := bucket.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Written within the expiry, nothing goes
+	if purged, err := purgeUploads(ctx, bucket, time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Fatalf("purged %d, %v", purged, err)
+	}
+	if purged, err := purgeUploads(ctx, bucket, time.Now().Add(time.Hour)); err != nil || purged != 2 {
+		t.Fatalf("purged %d, %v, want both uploads", purged, err)
+	}
+	for _, key := range keys {
+		exists, err := bucket.Exists(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := key == "ab/cdef"; exists != want {
+			t.Errorf("%s exists %v, want %v", key, exists, want)
+		}
+	}
+}
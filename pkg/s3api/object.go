@@ -0,0 +1,199 @@
+package s3api
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/atekoa/dvc-http-remote/pkg/bucketfs"
+	"github.com/atekoa/dvc-http-remote/pkg/trash"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+const metaPrefix = "X-Amz-Meta-"
+
+// Query parameters of a GetObject overriding the headers of the response,
+// mostly used by presigned URLs.
+var responseOverrides = map[string]string{
+	"response-content-type":        "Content-Type",
+	"response-content-language":    "Content-Language",
+	"response-expires":             "Expires",
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+}
+
+func (s *Server) headObject(w http.ResponseWriter, req *request) error {
+	attrs, err := req.conn.Attributes(req.Context(), req.key)
+	if err != nil {
+		return blobError(err)
+	}
+	writeObjectHeaders(w, attrs)
+	w.Header().Set("Content-Length", strconv.FormatInt(attrs.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) getObject(w http.ResponseWriter, req *request) error {
+	attrs, err := req.conn.Attributes(req.Context(), req.key)
+	if err != nil {
+		return blobError(err)
+	}
+
+	writeObjectHeaders(w, attrs)
+	if attrs.ContentType == "" {
+		// An object without a type is not sniffed by ServeContent
+		w.Header()["Content-Type"] = nil
+	}
+	for param, header := range responseOverrides {
+		if value := req.query.Get(param); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+
+	// ServeContent answers the Range, If-Range, If-Match and If-None-Match
+	// headers against the ETag and Last-Modified of the object.
+	content := bucketfs.NewReadSeeker(req.Context(), req.conn.Bucket, req.key, attrs.Size)
+	defer content.Close()
+	http.ServeContent(w, req.Request, "", attrs.ModTime, content)
+	if err := content.Err(); err != nil {
+		// The status is sent, the client sees a truncated body
+		log.
+			WithField("bucket", req.bucket).
+			WithField("key", req.key).
+			WithError(err).
+			Error("Cannot send object")
+	}
+	return nil
+}
+
+func writeObjectHeaders(w http.ResponseWriter, attrs *blob.Attributes) {
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Last-Modified", attrs.ModTime.UTC().Format(http.TimeFormat))
+	if attrs.ContentType != "" {
+		header.Set("Content-Type", attrs.ContentType)
+	}
+	if attrs.ContentEncoding != "" {
+		header.Set("Content-Encoding", attrs.ContentEncoding)
+	}
+	if attrs.ContentDisposition != "" {
+		header.Set("Content-Disposition", attrs.ContentDisposition)
+	}
+	if attrs.CacheControl != "" {
+		header.Set("Cache-Control", attrs.CacheControl)
+	}
+	if tag := etag(attrs.MD5); tag != "" {
+		header.Set("ETag", tag)
+	}
+	for key, value := range attrs.Metadata {
+		header.Set(metaPrefix+key, value)
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, req *request) error {
+	opts := &blob.WriterOptions{
+		ContentType:        req.Header.Get("Content-Type"),
+		ContentEncoding:    req.Header.Get("Content-Encoding"),
+		ContentDisposition: req.Header.Get("Content-Disposition"),
+		CacheControl:       req.Header.Get("Cache-Control"),
+	}
+	for name, values := range req.Header {
+		if strings.HasPrefix(name, metaPrefix) {
+			if opts.Metadata == nil {
+				opts.Metadata = map[string]string{}
+			}
+			opts.Metadata[strings.ToLower(strings.TrimPrefix(name, metaPrefix))] = values[0]
+		}
+	}
+	if opts.ContentEncoding == "aws-chunked" {
+		opts.ContentEncoding = ""
+	}
+
+	sum, err := writeObject(req, req.key, opts)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag(sum))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// writeObject stores the body of the request under key and returns its MD5.
+// The write is aborted, leaving any previous object in place, when the body
+// is short, its signature is wrong, or it does not match the Content-MD5
+// header or the checksum a DVC key holds.
+func writeObject(req *request, key string, opts *blob.WriterOptions) ([]byte, error) {
+	size := decodedLength(req.Request)
+	if size < 0 {
+		return nil, errMissingContentLength
+	}
+
+	declared, err := declaredMD5(req.Header.Get("Content-MD5"))
+	if err != nil {
+		return nil, err
+	}
+	if keyMD5 := bucketfs.ContentMD5(key); keyMD5 != nil {
+		if declared != nil && string(declared) != string(keyMD5) {
+			return nil, fmt.Errorf("%w: Content-MD5 does not match the key", errBadDigest)
+		}
+		declared = keyMD5
+	}
+	opts.ContentMD5 = declared
+
+	writeCtx, abort := context.WithCancel(req.Context())
+	defer abort()
+	writer, err := req.conn.NewWriter(writeCtx, key, opts)
+	if err != nil {
+		return nil, blobError(err)
+	}
+
+	hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(writer, hash), io.LimitReader(req.sig.body(req.Request), size+1))
+	if err == nil && n != size {
+		err = errIncompleteBody
+	}
+	if err != nil {
+		abort()
+		writer.Close()
+		return nil, blobError(err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, blobError(err)
+	}
+	return hash.Sum(nil), nil
+}
+
+func declaredMD5(header string) ([]byte, error) {
+	if header == "" {
+		return nil, nil
+	}
+	sum, err := base64.StdEncoding.DecodeString(header)
+	if err != nil || len(sum) != md5.Size {
+		return nil, errInvalidDigest
+	}
+	return sum, nil
+}
+
+// deleteObject moves the object to the trash of the remote. Deleting a
+// missing object succeeds, as it does on S3.
+func (s *Server) deleteObject(w http.ResponseWriter, req *request) error {
+	config := req.conn.Config()
+	if !config.AllowDelete {
+		return fmt.Errorf("%w: delete is not allowed on this remote", errAccessDenied)
+	}
+
+	err := trash.Move(req.Context(), req.conn.Bucket, config.Trash, req.key)
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return blobError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
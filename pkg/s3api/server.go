@@ -0,0 +1,236 @@
+// Package s3api serves the remotes of the registry through a subset of the
+// S3 API, so that tools only speaking S3 reach the same store as DVC.
+//
+// Buckets are the remotes, addressed by name, alias or id, and requests must
+// use path-style addressing: http://host/<remote>/<key>.
+package s3api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/envyaml"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"github.com/atekoa/dvc-http-remote/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// RemoteLoader is the registry of the remotes exposed as buckets.
+type RemoteLoader interface {
+	LoadConfig(remote string) (*pool.ConnectionConfig, error)
+	Remotes() []*pool.ConnectionConfig
+}
+
+// Server is an http.Handler speaking the S3 API.
+type Server struct {
+	Remotes     RemoteLoader
+	Pool        *pool.Pool
+	credentials map[string]Credential
+}
+
+// NewServer returns a Server accepting the requests signed with one of the
+// credentials.
+func NewServer(remotes RemoteLoader, connections *pool.Pool, credentials []Credential) *Server {
+	s := &Server{
+		Remotes:     remotes,
+		Pool:        connections,
+		credentials: map[string]Credential{},
+	}
+	for _, credential := range credentials {
+		s.credentials[credential.AccessKeyID] = credential
+	}
+	return s
+}
+
+type credentialsFile struct {
+	Credentials []Credential `yaml:"credentials"`
+}
+
+// LoadCredentials reads the access keys declared in the YAML file name.
+func LoadCredentials(name string) ([]Credential, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read S3 credentials, %w", err)
+	}
+
+	var file credentialsFile
+	if err := envyaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse S3 credentials %s, %w", name, err)
+	}
+	for i, credential := range file.Credentials {
+		if credential.AccessKeyID == "" || credential.SecretAccessKey == "" {
+			return nil, fmt.Errorf("credential #%d in %s, an access_key_id and a secret_access_key are required", i, name)
+		}
+	}
+	return file.Credentials, nil
+}
+
+// request is an authenticated request to a bucket or an object.
+type request struct {
+	*http.Request
+	bucket string
+	key    string
+	query  url.Values
+	sig    *signature
+	conn   *pool.CloudConn
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	sig, err := authenticate(r, s.credentials, time.Now())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	req := &request{
+		Request: r,
+		query:   r.URL.Query(),
+		sig:     sig,
+	}
+	req.bucket, req.key = path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		req.bucket, req.key = path[:i], path[i+1:]
+	}
+
+	if req.bucket == "" {
+		err = s.service(w, req)
+	} else {
+		err = s.withBucket(w, req)
+	}
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (s *Server) service(w http.ResponseWriter, req *request) error {
+	if req.Method != http.MethodGet {
+		return errMethodNotAllowed
+	}
+	return s.listBuckets(w, req)
+}
+
+func (s *Server) withBucket(w http.ResponseWriter, req *request) error {
+	conn, err := s.getConnection(req)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	req.conn = conn
+
+	if req.key == "" {
+		return s.bucketOperation(w, req)
+	}
+	if hidden(conn.Config(), req.key) {
+		return fmt.Errorf("%w: %s is reserved", errAccessDenied, req.key)
+	}
+	return s.objectOperation(w, req)
+}
+
+func (s *Server) bucketOperation(w http.ResponseWriter, req *request) error {
+	switch req.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodGet:
+		if _, ok := req.query["location"]; ok {
+			writeXML(w, locationConstraint{})
+			return nil
+		}
+		if req.query.Get("list-type") != "2" {
+			return fmt.Errorf("%w: only ListObjectsV2 is supported", errNotImplemented)
+		}
+		return s.listObjectsV2(w, req)
+	}
+	return errNotImplemented
+}
+
+func (s *Server) objectOperation(w http.ResponseWriter, req *request) error {
+	_, uploads := req.query["uploads"]
+	uploadID := req.query.Get("uploadId")
+
+	switch req.Method {
+	case http.MethodHead:
+		return s.headObject(w, req)
+	case http.MethodGet:
+		if uploadID != "" {
+			return errNotImplemented
+		}
+		return s.getObject(w, req)
+	case http.MethodPut:
+		if req.Header.Get("X-Amz-Copy-Source") != "" {
+			return fmt.Errorf("%w: CopyObject is not supported", errNotImplemented)
+		}
+		if uploadID != "" {
+			return s.uploadPart(w, req, uploadID)
+		}
+		return s.putObject(w, req)
+	case http.MethodPost:
+		if uploads {
+			return s.createMultipartUpload(w, req)
+		}
+		if uploadID != "" {
+			return s.completeMultipartUpload(w, req, uploadID)
+		}
+		return errNotImplemented
+	case http.MethodDelete:
+		if uploadID != "" {
+			return s.abortMultipartUpload(w, req, uploadID)
+		}
+		return s.deleteObject(w, req)
+	}
+	return errMethodNotAllowed
+}
+
+func (s *Server) getConnection(req *request) (*pool.CloudConn, error) {
+	config, errLoad := s.Remotes.LoadConfig(req.bucket)
+	if errors.Is(errLoad, storage.ErrRemoteNotFound) {
+		return nil, fmt.Errorf("%w: %s", errNoSuchBucket, req.bucket)
+	}
+	if errLoad != nil {
+		return nil, fmt.Errorf("%w: %v", errBucketConfigNotLoaded, errLoad)
+	}
+
+	if errACL := config.ACL.Check(req.sig.credential.identity(), auth.AccessOf(req.Method), req.Request, req.bucket); errACL != nil {
+		return nil, fmt.Errorf("%w: %v on bucket %s", errAccessDenied, errACL, req.bucket)
+	}
+
+	conn, errGet := s.Pool.Get(req.Context(), config)
+	if errGet != nil {
+		log.
+			WithField("remote", req.bucket).
+			WithField("type", config.Type).
+			WithError(errGet).
+			Error("Cannot create connections")
+		return nil, errServiceUnavailable
+	}
+	return conn, nil
+}
+
+// hidden reports whether key belongs to the trash or to the parts of the
+// multipart uploads, which are not part of the bucket seen by clients.
+func hidden(config *pool.ConnectionConfig, key string) bool {
+	for _, prefix := range []string{config.Trash.Prefix, MultipartPrefix} {
+		if prefix != "" && (key == prefix || strings.HasPrefix(key, prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketName is the name a remote is listed under.
+func bucketName(config *pool.ConnectionConfig) string {
+	if config.Name != "" {
+		return config.Name
+	}
+	return strconv.Itoa(config.RemoteId)
+}
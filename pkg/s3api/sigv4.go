@@ -0,0 +1,423 @@
+package s3api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+)
+
+const (
+	signV4Algorithm     = "AWS4-HMAC-SHA256"
+	iso8601Format       = "20060102T150405Z"
+	yyyymmdd            = "20060102"
+	unsignedPayload     = "UNSIGNED-PAYLOAD"
+	streamingPayload    = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingTrailer    = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsigned   = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256         = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxClockSkew        = 15 * time.Minute
+	maxPresignedExpires = 7 * 24 * time.Hour
+)
+
+// Credential is an access key accepted by the S3 frontend. User and Groups
+// are the identity checked against the ACL of the remotes, User defaults to
+// the access key id.
+type Credential struct {
+	AccessKeyID     string   `yaml:"access_key_id"`
+	SecretAccessKey string   `yaml:"secret_access_key"`
+	User            string   `yaml:"user"`
+	Groups          []string `yaml:"groups"`
+}
+
+// identity returns the identity of the callers using the credential.
+func (c Credential) identity() *auth.Identity {
+	user := c.User
+	if user == "" {
+		user = c.AccessKeyID
+	}
+	return &auth.Identity{User: user, Groups: c.Groups, Method: "sigv4"}
+}
+
+// signature holds what is needed to verify a request and its payload.
+type signature struct {
+	credential    Credential
+	amzDate       string
+	scope         string
+	signingKey    []byte
+	seed          string
+	payloadSHA256 string
+}
+
+// authenticate verifies the SigV4 signature of r, given in the Authorization
+// header or in the query string of a presigned URL.
+func authenticate(r *http.Request, credentials map[string]Credential, now time.Time) (*signature, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return verifyHeader(r, auth, credentials, now)
+	}
+	if r.URL.Query().Get("X-Amz-Algorithm") != "" {
+		return verifyPresigned(r, credentials, now)
+	}
+	return nil, errAccessDenied
+}
+
+func verifyHeader(r *http.Request, auth string, credentials map[string]Credential, now time.Time) (*signature, error) {
+	if !strings.HasPrefix(auth, signV4Algorithm+" ") {
+		return nil, fmt.Errorf("%w: only %s is supported", errAccessDenied, signV4Algorithm)
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(auth, signV4Algorithm+" "), ",") {
+		field = strings.TrimSpace(field)
+		if i := strings.Index(field, "="); i > 0 {
+			fields[field[:i]] = field[i+1:]
+		}
+	}
+
+	if err := checkSignedHeaders(r, fields["SignedHeaders"], true); err != nil {
+		return nil, err
+	}
+
+	var date time.Time
+	var err error
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate != "" {
+		date, err = time.Parse(iso8601Format, amzDate)
+	} else {
+		// Without X-Amz-Date the HTTP Date is signed, in the ISO 8601 format
+		date, err = http.ParseTime(r.Header.Get("Date"))
+		amzDate = date.UTC().Format(iso8601Format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid X-Amz-Date or Date", errAccessDenied)
+	}
+	if skew := now.Sub(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, errSkewed
+	}
+
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = emptySHA256
+	}
+	return verify(r, fields["Credential"], fields["SignedHeaders"], fields["Signature"], amzDate, r.URL.Query(), payload, credentials)
+}
+
+func verifyPresigned(r *http.Request, credentials map[string]Credential, now time.Time) (*signature, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != signV4Algorithm {
+		return nil, fmt.Errorf("%w: only %s is supported", errAccessDenied, signV4Algorithm)
+	}
+
+	if err := checkSignedHeaders(r, query.Get("X-Amz-SignedHeaders"), false); err != nil {
+		return nil, err
+	}
+
+	amzDate := query.Get("X-Amz-Date")
+	date, err := time.Parse(iso8601Format, amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid date %q", errAccessDenied, amzDate)
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignedExpires {
+		return nil, fmt.Errorf("%w: invalid expiration", errAccessDenied)
+	}
+	if now.Before(date.Add(-maxClockSkew)) {
+		return nil, errSkewed
+	}
+	if now.After(date.Add(time.Duration(expires) * time.Second)) {
+		return nil, errExpired
+	}
+
+	signed := url.Values{}
+	for key, values := range query {
+		if key != "X-Amz-Signature" {
+			signed[key] = values
+		}
+	}
+	return verify(r, query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature"), amzDate, signed, unsignedPayload, credentials)
+}
+
+// checkSignedHeaders rejects the signatures that do not cover the host, or,
+// with amzHeaders, one of the x-amz-* headers of r. Unsigned, they could be
+// changed without breaking the signature.
+func checkSignedHeaders(r *http.Request, signedHeaders string, amzHeaders bool) error {
+	signed := map[string]bool{}
+	for _, name := range strings.Split(signedHeaders, ";") {
+		signed[name] = true
+	}
+	if !signed["host"] {
+		return fmt.Errorf("%w: the host header is not signed", errAccessDenied)
+	}
+	if !amzHeaders {
+		return nil
+	}
+	for name := range r.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") && !signed[name] {
+			return fmt.Errorf("%w: the %s header is not signed", errAccessDenied, name)
+		}
+	}
+	return nil
+}
+
+func verify(r *http.Request, credentialField, signedHeaders, sig, amzDate string, query url.Values, payload string, credentials map[string]Credential) (*signature, error) {
+	// AKID/20060102/region/s3/aws4_request
+	parts := strings.Split(credentialField, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return nil, fmt.Errorf("%w: malformed credential %q", errAccessDenied, credentialField)
+	}
+	credential, ok := credentials[parts[0]]
+	if !ok {
+		return nil, errInvalidAccessKey
+	}
+	if !strings.HasPrefix(amzDate, parts[1]) {
+		return nil, fmt.Errorf("%w: credential date does not match", errSignature)
+	}
+	if signedHeaders == "" || sig == "" {
+		return nil, fmt.Errorf("%w: missing signature", errAccessDenied)
+	}
+
+	scope := strings.Join(parts[1:], "/")
+	canonical := canonicalRequest(r, query, strings.Split(signedHeaders, ";"), signedHeaders, payload)
+	stringToSign := strings.Join([]string{signV4Algorithm, amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+
+	key := signingKey(credential.SecretAccessKey, parts[1], parts[2], parts[3])
+	expected := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return nil, errSignature
+	}
+	return &signature{
+		credential:    credential,
+		amzDate:       amzDate,
+		scope:         scope,
+		signingKey:    key,
+		seed:          sig,
+		payloadSHA256: payload,
+	}, nil
+}
+
+func canonicalRequest(r *http.Request, query url.Values, headers []string, signedHeaders, payload string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+	b.WriteString(uriEncode(r.URL.Path, false))
+	b.WriteByte('\n')
+	b.WriteString(canonicalQuery(query))
+	b.WriteByte('\n')
+	for _, name := range headers {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(canonicalHeaderValue(r, name))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.WriteString(signedHeaders)
+	b.WriteByte('\n')
+	b.WriteString(payload)
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		values = []string{r.Host}
+	case "content-length":
+		values = []string{strconv.FormatInt(r.ContentLength, 10)}
+	case "transfer-encoding":
+		values = r.TransferEncoding
+	default:
+		values = r.Header.Values(name)
+	}
+	// values may be those of the request, trimmed in a copy
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(trimmed, ",")
+}
+
+// uriEncode encodes s the way SigV4 expects: every byte but the unreserved
+// characters is percent encoded, and so is "/" when encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// body returns the payload of r, decoding aws-chunked uploads and checking
+// what the signature covers as it is read.
+func (sig *signature) body(r *http.Request) io.Reader {
+	switch sig.payloadSHA256 {
+	case unsignedPayload:
+		return r.Body
+	case streamingPayload, streamingTrailer:
+		return &chunkedReader{r: bufio.NewReader(r.Body), sig: sig, prev: sig.seed, signed: true}
+	case streamingUnsigned:
+		return &chunkedReader{r: bufio.NewReader(r.Body), sig: sig}
+	default:
+		return &hashingReader{r: r.Body, hash: sha256.New(), expected: sig.payloadSHA256}
+	}
+}
+
+// decodedLength is the size of the object carried by r.
+func decodedLength(r *http.Request) int64 {
+	if length := r.Header.Get("X-Amz-Decoded-Content-Length"); length != "" {
+		if n, err := strconv.ParseInt(length, 10, 64); err == nil {
+			return n
+		}
+		return -1
+	}
+	return r.ContentLength
+}
+
+// hashingReader fails at EOF when the payload does not match its signed
+// SHA256, so that a tampered upload is never committed.
+type hashingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(h.hash.Sum(nil)) != h.expected {
+		return n, errContentSHA256
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked payload:
+//
+//	<hex size>;chunk-signature=<signature>\r\n<data>\r\n ... 0;chunk-signature=<signature>\r\n
+//
+// The signature of each chunk chains to the previous one. Trailing headers,
+// holding the checksums newer SDKs add, are skipped.
+type chunkedReader struct {
+	r      *bufio.Reader
+	sig    *signature
+	prev   string
+	signed bool
+
+	chunk []byte
+	done  bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) next() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return errMalformedChunk
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	sizeField, chunkSignature := line, ""
+	if i := strings.Index(line, ";"); i >= 0 {
+		sizeField = line[:i]
+		chunkSignature = strings.TrimPrefix(line[i+1:], "chunk-signature=")
+	}
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || size > 64<<20 {
+		return errMalformedChunk
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return errMalformedChunk
+	}
+
+	if c.signed {
+		stringToSign := strings.Join([]string{
+			"AWS4-HMAC-SHA256-PAYLOAD",
+			c.sig.amzDate,
+			c.sig.scope,
+			c.prev,
+			emptySHA256,
+			sha256Hex(data),
+		}, "\n")
+		expected := hex.EncodeToString(hmacSHA256(c.sig.signingKey, []byte(stringToSign)))
+		if !hmac.Equal([]byte(expected), []byte(chunkSignature)) {
+			return errSignature
+		}
+		c.prev = chunkSignature
+	}
+
+	if size == 0 {
+		// Skip the trailers up to the empty line ending the payload.
+		for {
+			line, err := c.r.ReadString('\n')
+			if len(bytes.TrimSpace([]byte(line))) == 0 || err != nil {
+				break
+			}
+		}
+		c.done = true
+		return nil
+	}
+
+	if crlf, err := c.r.ReadString('\n'); err != nil || strings.TrimRight(crlf, "\r\n") != "" {
+		return errMalformedChunk
+	}
+	c.chunk = data
+	return nil
+}
@@ -0,0 +1,347 @@
+package s3api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const (
+	testRegion    = "eu-west-1"
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+var (
+	signTime        = time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	testCredentials = map[string]Credential{testAccessKey: {AccessKeyID: testAccessKey, SecretAccessKey: testSecretKey}}
+)
+
+// signer signs like the S3 client of the SDK, which escapes the keys in the
+// path once.
+func signer(secret string) *v4.Signer {
+	return v4.NewSigner(credentials.NewStaticCredentials(testAccessKey, secret, ""), func(s *v4.Signer) {
+		s.DisableURIPathEscaping = true
+	})
+}
+
+// received turns a request signed by a client into the request the server
+// gets, where Content-Length is no longer a header.
+func received(client *http.Request, body string) *http.Request {
+	r := httptest.NewRequest(client.Method, client.URL.String(), strings.NewReader(body))
+	for name, values := range client.Header {
+		if name != "Content-Length" {
+			r.Header[name] = values
+		}
+	}
+	return r
+}
+
+func TestVerifyHeader(t *testing.T) {
+	tests := []struct {
+		name              string
+		method, url, body string
+		secret            string
+		header            http.Header
+		// change alters the request after it is signed
+		change func(r *http.Request)
+		now    time.Time
+		err    error
+		// readErr is the error reading the body
+		readErr error
+	}{
+		{name: "get", method: http.MethodGet, url: "http://s3.test/bucket/ab/cd"},
+		{name: "put", method: http.MethodPut, url: "http://s3.test/bucket/ab/cd", body: "content"},
+		{name: "escaped key", method: http.MethodGet, url: "http://s3.test/bucket/a%20b/c%2Bd%3D~"},
+		{name: "query", method: http.MethodGet, url: "http://s3.test/bucket?list-type=2&prefix=ab%2F&delimiter=%2F"},
+		{name: "metadata", method: http.MethodPut, url: "http://s3.test/bucket/ab/cd", body: "content", header: http.Header{"X-Amz-Meta-Note": {"a  spaced   note"}}},
+		{
+			name: "wrong secret", method: http.MethodGet, url: "http://s3.test/bucket/ab/cd",
+			secret: "another secret", err: errSignature,
+		},
+		{
+			name: "unknown access key", method: http.MethodGet, url: "http://s3.test/bucket/ab/cd",
+			change: func(r *http.Request) {
+				r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), testAccessKey, "AKIDUNKNOWN", 1))
+			},
+			err: errInvalidAccessKey,
+		},
+		{
+			name: "tampered path", method: http.MethodGet, url: "http://s3.test/bucket/ab/cd",
+			change: func(r *http.Request) { r.URL.Path = "/bucket/ab/ef" },
+			err:    errSignature,
+		},
+		{
+			name: "tampered query", method: http.MethodGet, url: "http://s3.test/bucket?prefix=ab",
+			change: func(r *http.Request) { r.URL.RawQuery = "prefix=ef" },
+			err:    errSignature,
+		},
+		{
+			name: "tampered body", method: http.MethodPut, url: "http://s3.test/bucket/ab/cd", body: "content",
+			change:  func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader("CONTENT")) },
+			readErr: errContentSHA256,
+		},
+		{
+			name: "unsigned amz header", method: http.MethodPut, url: "http://s3.test/bucket/ab/cd", body: "content",
+			change: func(r *http.Request) { r.Header.Set("X-Amz-Meta-Added", "value") },
+			err:    errAccessDenied,
+		},
+		{
+			name: "unsigned host", method: http.MethodGet, url: "http://s3.test/bucket/ab/cd",
+			change: func(r *http.Request) {
+				r.Header.Set("Authorization", regexp.MustCompile(`SignedHeaders=[^,]*`).ReplaceAllString(r.Header.Get("Authorization"), "SignedHeaders=x-amz-content-sha256;x-amz-date"))
+			},
+			err: errAccessDenied,
+		},
+		{
+			name: "skewed", method: http.MethodGet, url: "http://s3.test/bucket/ab/cd",
+			now: signTime.Add(time.Hour), err: errSkewed,
+		},
+		{
+			name: "invalid date", method: http.MethodGet, url: "http://s3.test/bucket/ab/cd",
+			change: func(r *http.Request) { r.Header.Set("X-Amz-Date", "yesterday") },
+			err:    errAccessDenied,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, _ := http.NewRequest(test.method, test.url, nil)
+			for name, values := range test.header {
+				client.Header[name] = values
+			}
+			secret := test.secret
+			if secret == "" {
+				secret = testSecretKey
+			}
+			if _, err := signer(secret).Sign(client, strings.NewReader(test.body), "s3", testRegion, signTime); err != nil {
+				t.Fatal(err)
+			}
+
+			r := received(client, test.body)
+			if test.change != nil {
+				test.change(r)
+			}
+			header := r.Header.Clone()
+			now := test.now
+			if now.IsZero() {
+				now = signTime.Add(time.Minute)
+			}
+
+			sig, err := authenticate(r, testCredentials, now)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("authenticate returned %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if fmt.Sprint(r.Header) != fmt.Sprint(header) {
+				t.Errorf("the headers changed to %v", r.Header)
+			}
+			if sig.credential.AccessKeyID != testAccessKey {
+				t.Errorf("credential %s", sig.credential.AccessKeyID)
+			}
+			body, err := io.ReadAll(sig.body(r))
+			if !errors.Is(err, test.readErr) {
+				t.Fatalf("reading the body returned %v, want %v", err, test.readErr)
+			}
+			if err == nil && string(body) != test.body {
+				t.Errorf("body %q, want %q", body, test.body)
+			}
+		})
+	}
+}
+
+func TestVerifyHeaderDate(t *testing.T) {
+	// A request dated by Date only, signed the way S3 documents it
+	r := httptest.NewRequest(http.MethodGet, "http://s3.test/bucket/ab/cd", nil)
+	r.Header.Set("Date", signTime.Format(http.TimeFormat))
+	r.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	scope := signTime.Format(yyyymmdd) + "/" + testRegion + "/s3/aws4_request"
+	canonical := strings.Join([]string{
+		"GET", "/bucket/ab/cd", "",
+		"date:" + signTime.Format(http.TimeFormat),
+		"host:s3.test",
+		"x-amz-content-sha256:" + emptySHA256,
+		"",
+		"date;host;x-amz-content-sha256",
+		emptySHA256,
+	}, "\n")
+	stringToSign := strings.Join([]string{signV4Algorithm, signTime.Format(iso8601Format), scope, sha256Hex([]byte(canonical))}, "\n")
+	key := signingKey(testSecretKey, signTime.Format(yyyymmdd), testRegion, "s3")
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=date;host;x-amz-content-sha256, Signature=%s",
+		signV4Algorithm, testAccessKey, scope, hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))))
+
+	if _, err := authenticate(r, testCredentials, signTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticate(r, testCredentials, signTime.Add(time.Hour)); !errors.Is(err, errSkewed) {
+		t.Errorf("an old Date returned %v, want %v", err, errSkewed)
+	}
+}
+
+func TestVerifyPresigned(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		expires time.Duration
+		change  func(r *http.Request)
+		now     time.Time
+		err     error
+	}{
+		{name: "get", method: http.MethodGet, expires: time.Hour},
+		{name: "put", method: http.MethodPut, expires: time.Hour},
+		{name: "expired", method: http.MethodGet, expires: time.Minute, now: signTime.Add(2 * time.Minute), err: errExpired},
+		{name: "too long", method: http.MethodGet, expires: 8 * 24 * time.Hour, err: errAccessDenied},
+		{
+			name: "tampered path", method: http.MethodGet, expires: time.Hour,
+			change: func(r *http.Request) { r.URL.Path = "/bucket/ab/ef" },
+			err:    errSignature,
+		},
+		{
+			name: "tampered method", method: http.MethodGet, expires: time.Hour,
+			change: func(r *http.Request) { r.Method = http.MethodDelete },
+			err:    errSignature,
+		},
+		{
+			name: "tampered expiry", method: http.MethodGet, expires: time.Hour,
+			change: func(r *http.Request) {
+				query := r.URL.Query()
+				query.Set("X-Amz-Expires", "7200")
+				r.URL.RawQuery = query.Encode()
+			},
+			err: errSignature,
+		},
+		{
+			name: "tampered host", method: http.MethodGet, expires: time.Hour,
+			change: func(r *http.Request) { r.Host = "other.test" },
+			err:    errSignature,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, _ := http.NewRequest(test.method, "http://s3.test/bucket/ab/cd", nil)
+			if _, err := signer(testSecretKey).Presign(client, nil, "s3", testRegion, test.expires, signTime); err != nil {
+				t.Fatal(err)
+			}
+			r := received(client, "")
+			if test.change != nil {
+				test.change(r)
+			}
+			now := test.now
+			if now.IsZero() {
+				now = signTime.Add(time.Minute)
+			}
+
+			sig, err := authenticate(r, testCredentials, now)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("authenticate returned %v, want %v", err, test.err)
+			}
+			if err == nil && sig.payloadSHA256 != unsignedPayload {
+				t.Errorf("payload %s, want %s", sig.payloadSHA256, unsignedPayload)
+			}
+		})
+	}
+}
+
+// chunkedRequest signs an aws-chunked upload of chunks, the chunk signatures
+// chaining to the seed signature of the headers.
+func chunkedRequest(t *testing.T, chunks []string, trailer string) (*http.Request, string) {
+	t.Helper()
+	decoded := strings.Join(chunks, "")
+	client, _ := http.NewRequest(http.MethodPut, "http://s3.test/bucket/ab/cd", nil)
+	client.Header.Set("Content-Encoding", "aws-chunked")
+	client.Header.Set("X-Amz-Decoded-Content-Length", strconv.Itoa(len(decoded)))
+	client.Header.Set("X-Amz-Content-Sha256", streamingPayload)
+	s := signer(testSecretKey)
+	if _, err := s.Sign(client, nil, "s3", testRegion, signTime); err != nil {
+		t.Fatal(err)
+	}
+
+	seed, err := hex.DecodeString(regexp.MustCompile(`Signature=([0-9a-f]+)`).FindStringSubmatch(client.Header.Get("Authorization"))[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamSigner := v4.NewStreamSigner(testRegion, "s3", seed, s.Credentials)
+	var body bytes.Buffer
+	for _, chunk := range append(chunks, "") {
+		signature, err := streamSigner.GetSignature(nil, []byte(chunk), signTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&body, "%x;chunk-signature=%x\r\n%s", len(chunk), signature, chunk)
+		if chunk != "" {
+			body.WriteString("\r\n")
+		}
+	}
+	body.WriteString(trailer + "\r\n")
+	return received(client, body.String()), decoded
+}
+
+func TestChunkedPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []string
+		trailer string
+		tamper  func(body string) string
+		err     error
+	}{
+		{name: "one chunk", chunks: []string{"content"}},
+		{name: "several chunks", chunks: []string{strings.Repeat("a", 8192), strings.Repeat("b", 8192), "end"}},
+		{name: "trailer", chunks: []string{"content"}, trailer: "x-amz-checksum-crc32:AAAAAA==\r\n"},
+		{
+			name: "tampered data", chunks: []string{"content", "more"},
+			tamper: func(body string) string { return strings.Replace(body, "more", "MORE", 1) },
+			err:    errSignature,
+		},
+		{
+			name: "dropped chunk", chunks: []string{"content", "more"},
+			tamper: func(body string) string {
+				i := strings.Index(body, "\r\ncontent\r\n") + len("\r\ncontent\r\n")
+				j := strings.Index(body, "more\r\n") + len("more\r\n")
+				return body[:i] + body[j:]
+			},
+			err: errSignature,
+		},
+		{
+			name: "truncated", chunks: []string{"content"},
+			tamper: func(body string) string { return body[:len(body)/2] },
+			err:    errMalformedChunk,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, decoded := chunkedRequest(t, test.chunks, test.trailer)
+			if test.tamper != nil {
+				body, _ := io.ReadAll(r.Body)
+				r.Body = io.NopCloser(strings.NewReader(test.tamper(string(body))))
+			}
+
+			sig, err := authenticate(r, testCredentials, signTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodedLength(r); got != int64(len(decoded)) {
+				t.Errorf("decoded length %d, want %d", got, len(decoded))
+			}
+			body, err := io.ReadAll(sig.body(r))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("reading the body returned %v, want %v", err, test.err)
+			}
+			if err == nil && string(body) != decoded {
+				t.Errorf("body %q, want %q", body, decoded)
+			}
+		})
+	}
+}
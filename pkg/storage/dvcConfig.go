@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/atekoa/dvc-http-remote/pkg/dvc"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	log "github.com/sirupsen/logrus"
+)
+
+// LoadDVCConfig registers the remotes declared in DVC config files, e.g.
+// .dvc/config followed by .dvc/config.local whose values take precedence.
+// Remotes that cannot be proxied or whose name is already taken are skipped.
+// The core.remote of the files becomes the default remote if there is none.
+func (s *storageSiteLoader) LoadDVCConfig(files ...string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	first, err := os.Open(files[0])
+	if err != nil {
+		return fmt.Errorf("cannot open DVC config, %w", err)
+	}
+	defer first.Close()
+
+	others := make([]interface{}, 0, len(files)-1)
+	for _, file := range files[1:] {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			// config.local is usually not there
+			continue
+		}
+		others = append(others, file)
+	}
+
+	parser, err := dvc.NewDVCConfig(first, others...)
+	if err != nil {
+		return fmt.Errorf("cannot parse DVC config, %w", err)
+	}
+
+	for _, remote := range parser.ListRemotes() {
+		config, err := parser.GetRemoteConfig(remote)
+		if errors.Is(err, dvc.ErrHTTPRemote) {
+			log.
+				WithField("remote", remote).
+				WithError(err).
+				Info("Skipping DVC HTTP remote")
+			continue
+		}
+		if err != nil {
+			log.
+				WithField("remote", remote).
+				WithError(err).
+				Warn("Skipping DVC remote")
+			continue
+		}
+		if config.Type == pool.ConfigTypeHttp && !filepath.IsAbs(config.ContainerName) {
+			// DVC resolves local remotes from the folder holding its config
+			config.ContainerName = filepath.Join(filepath.Dir(files[0]), config.ContainerName)
+		}
+		config.Compression = compressionPolicyFromEnv()
+		config.Trash = defaultTrashPolicy()
+
+		if err := s.register(config); err != nil {
+			log.
+				WithField("remote", remote).
+				WithError(err).
+				Warn("Skipping DVC remote")
+			continue
+		}
+		log.
+			WithField("remote", remote).
+			WithField("type", config.Type).
+			Info("Registered DVC remote")
+	}
+
+	s.mu.RLock()
+	hasDefault := s.defaultRemote != ""
+	s.mu.RUnlock()
+	if defaultRemote := parser.DefaultRemote(); defaultRemote != "" && !hasDefault {
+		if err := s.SetDefault(defaultRemote); err != nil {
+			log.
+				WithField("remote", defaultRemote).
+				WithError(err).
+				Warn("Cannot use the DVC default remote")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+)
+
+func TestLoadDVCConfig(t *testing.T) {
+	loader := newTestLoader(t)
+	dir := filepath.Join(t.TempDir(), ".dvc")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config")
+	if err := os.WriteFile(config, []byte(`[core]
+    remote = MyData
+['remote "MyData"']
+    url = azure://test/dvc
+    connection_string = AccountName=account;AccountKey=a2V5
+['remote "Minio"']
+    url = s3://bucket/store
+    endpointurl = http://minio:9000
+['remote "proxy"']
+    url = https://dvc.example.com/remote
+['remote "scratch"']
+    url = ../cache
+['remote "local"']
+    url = /elsewhere
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(dir, "config.local")
+	if err := os.WriteFile(local, []byte(`['remote "Minio"']
+    access_key_id = AKIDLOCAL
+    Secret_Access_Key = secret
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// config.local is optional
+	if err := loader.LoadDVCConfig(config, local, filepath.Join(dir, "missing")); err != nil {
+		t.Fatal(err)
+	}
+
+	myData, err := loader.LoadConfig("")
+	if err != nil {
+		t.Fatalf("core.remote is not the default remote, %v", err)
+	}
+	if myData.Name != "MyData" || myData.Type != pool.ConfigTypeAzure || myData.ContainerName != "test" || myData.Prefix != "dvc" || myData.AccountName != "account" {
+		t.Errorf("MyData %+v", myData)
+	}
+	if _, err := loader.LoadConfig("mydata"); !errors.Is(err, ErrRemoteNotFound) {
+		t.Errorf("remote names are case sensitive, mydata returned %v", err)
+	}
+
+	minio, err := loader.LoadConfig("Minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minio.Type != pool.ConfigTypeS3 || minio.S3.Endpoint != "http://minio:9000" || !minio.S3.PathStyle {
+		t.Errorf("Minio %+v", minio.S3)
+	}
+	if minio.S3.AccessKeyID != "AKIDLOCAL" || minio.S3.SecretAccessKey != "secret" {
+		t.Errorf("Minio credentials of config.local %+v", minio.S3)
+	}
+
+	scratch, err := loader.LoadConfig("scratch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(filepath.Dir(dir), "cache"); scratch.Type != pool.ConfigTypeHttp || scratch.ContainerName != want {
+		t.Errorf("scratch %s %s, want a local remote in %s", scratch.Type, scratch.ContainerName, want)
+	}
+
+	if _, err := loader.LoadConfig("proxy"); !errors.Is(err, ErrRemoteNotFound) {
+		t.Errorf("HTTP remote registered, %v", err)
+	}
+	// local is already the built-in remote
+	if builtIn, err := loader.LoadConfig("local"); err != nil || builtIn.ContainerName == "/elsewhere" {
+		t.Errorf("built-in remote replaced, %+v %v", builtIn, err)
+	}
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/auth"
+	"github.com/atekoa/dvc-http-remote/pkg/dvc"
+	"github.com/atekoa/dvc-http-remote/pkg/envyaml"
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+)
+
+// registryFile is the layout of the remotes configuration file:
+//
+//	default: datasets
+//	remotes:
+//	  - id: 0
+//	    name: local
+//	    type: local
+//	    container: remote-folder
+//	  - id: 1
+//	    name: datasets
+//	    aliases: [datasets-eu]
+//	    type: azure
+//	    container: test
+//	    prefix: dvc
+//	    connection_string: ${AZURE_CONNECTION_STRING}
+//	    allow_delete: true
+//	    trash:
+//	      retention: 72h
+//	    redirect:
+//	      min_size: 1048576
+//	      expiry: 15m
+//	    acl:
+//	      read: [anonymous]
+//	      write: [ci, group:ml]
+//	  - name: minio
+//	    type: s3
+//	    container: dvc
+//	    endpoint: http://minio:9000
+//	    path_style: true
+//	    access_key_id: ${MINIO_ACCESS_KEY}
+//	    secret_access_key: ${MINIO_SECRET_KEY}
+//
+// Environment variables are expanded before the file is parsed, so
+// credentials do not need to be written in it.
+type registryFile struct {
+	Default string        `yaml:"default"`
+	Remotes []remoteEntry `yaml:"remotes"`
+}
+
+type remoteEntry struct {
+	ID        *int     `yaml:"id"`
+	Name      string   `yaml:"name"`
+	Aliases   []string `yaml:"aliases"`
+	Type      string   `yaml:"type"`
+	Container string   `yaml:"container"`
+	Prefix    string   `yaml:"prefix"`
+
+	ConnectionString string `yaml:"connection_string"`
+	AccountName      string `yaml:"account_name"`
+	AccountKey       string `yaml:"account_key"`
+
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	PathStyle       bool   `yaml:"path_style"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+
+	CredentialsFile string `yaml:"credentials_file"`
+	CredentialsJSON string `yaml:"credentials_json"`
+	EmulatorHost    string `yaml:"emulator_host"`
+
+	MaxSize int64 `yaml:"max_size"`
+
+	Compression *compressionEntry `yaml:"compression"`
+
+	AllowDelete bool        `yaml:"allow_delete"`
+	Trash       *trashEntry `yaml:"trash"`
+
+	ACL *aclEntry `yaml:"acl"`
+
+	Redirect *redirectEntry `yaml:"redirect"`
+}
+
+type redirectEntry struct {
+	Enabled *bool          `yaml:"enabled"`
+	MinSize *int64         `yaml:"min_size"`
+	Expiry  *time.Duration `yaml:"expiry"`
+}
+
+type aclEntry struct {
+	Read  []string `yaml:"read"`
+	Write []string `yaml:"write"`
+}
+
+type trashEntry struct {
+	Prefix    string         `yaml:"prefix"`
+	Retention *time.Duration `yaml:"retention"`
+}
+
+type compressionEntry struct {
+	Enabled      *bool    `yaml:"enabled"`
+	MinSize      *int64   `yaml:"min_size"`
+	ContentTypes []string `yaml:"content_types"`
+	SkipTypes    []string `yaml:"skip_types"`
+}
+
+// loadRegistryFile returns the remotes declared in the file name and the
+// identifier of the default one.
+func loadRegistryFile(name string) ([]*pool.ConnectionConfig, string, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot read remotes configuration, %w", err)
+	}
+
+	var file registryFile
+	if err := envyaml.Unmarshal(content, &file); err != nil {
+		return nil, "", fmt.Errorf("cannot parse remotes configuration %s, %w", name, err)
+	}
+
+	configs := make([]*pool.ConnectionConfig, 0, len(file.Remotes))
+	for i, entry := range file.Remotes {
+		config, err := entry.connectionConfig()
+		if err != nil {
+			return nil, "", fmt.Errorf("remote #%d in %s, %w", i, name, err)
+		}
+		configs = append(configs, config)
+	}
+	return configs, file.Default, nil
+}
+
+func (entry remoteEntry) connectionConfig() (*pool.ConnectionConfig, error) {
+	if entry.ID == nil && entry.Name == "" {
+		return nil, fmt.Errorf("an id or a name is required")
+	}
+	if entry.Container == "" && pool.ConfigType(strings.ToLower(entry.Type)) != pool.ConfigTypeMemory {
+		return nil, fmt.Errorf("container is required")
+	}
+
+	config := &pool.ConnectionConfig{
+		Name:          entry.Name,
+		Aliases:       entry.Aliases,
+		ContainerName: entry.Container,
+		Prefix:        entry.Prefix,
+		RemoteId:      -1,
+		Compression:   compressionPolicyFromEnv(),
+		AllowDelete:   entry.AllowDelete,
+		Trash:         defaultTrashPolicy(),
+	}
+	if entry.ID != nil {
+		if *entry.ID < 0 {
+			return nil, fmt.Errorf("id %d must be positive", *entry.ID)
+		}
+		config.RemoteId = *entry.ID
+	}
+
+	switch pool.ConfigType(strings.ToLower(entry.Type)) {
+	case pool.ConfigTypeHttp, "local":
+		config.Type = pool.ConfigTypeHttp
+	case pool.ConfigTypeAzure:
+		config.Type = pool.ConfigTypeAzure
+		config.ConnectionString = entry.ConnectionString
+		config.AccountName = entry.AccountName
+		config.AccountKey = entry.AccountKey
+		if entry.ConnectionString != "" {
+			connectionParams, err := dvc.Parse(entry.ConnectionString)
+			if err != nil {
+				return nil, err
+			}
+			if config.AccountName == "" {
+				config.AccountName = connectionParams["AccountName"]
+			}
+			if config.AccountKey == "" {
+				config.AccountKey = connectionParams["AccountKey"]
+			}
+		}
+	case pool.ConfigTypeS3:
+		config.Type = pool.ConfigTypeS3
+		config.S3 = pool.S3Config{
+			Endpoint:        entry.Endpoint,
+			Region:          entry.Region,
+			PathStyle:       entry.PathStyle,
+			AccessKeyID:     entry.AccessKeyID,
+			SecretAccessKey: entry.SecretAccessKey,
+			SessionToken:    entry.SessionToken,
+		}
+	case pool.ConfigTypeGCS, "gs":
+		config.Type = pool.ConfigTypeGCS
+		config.GCS = pool.GCSConfig{
+			CredentialsFile: entry.CredentialsFile,
+			CredentialsJSON: entry.CredentialsJSON,
+			EmulatorHost:    entry.EmulatorHost,
+		}
+	case pool.ConfigTypeMemory:
+		config.Type = pool.ConfigTypeMemory
+		config.Memory = pool.MemoryConfig{
+			MaxSize: entry.MaxSize,
+		}
+	default:
+		return nil, fmt.Errorf("unknown remote type %q", entry.Type)
+	}
+
+	if entry.Compression != nil {
+		if entry.Compression.Enabled != nil {
+			config.Compression.Enabled = *entry.Compression.Enabled
+		}
+		if entry.Compression.MinSize != nil {
+			config.Compression.MinSize = *entry.Compression.MinSize
+		}
+		if entry.Compression.ContentTypes != nil {
+			config.Compression.ContentTypes = entry.Compression.ContentTypes
+		}
+		if entry.Compression.SkipTypes != nil {
+			config.Compression.SkipTypes = entry.Compression.SkipTypes
+		}
+	}
+	if entry.Trash != nil {
+		if entry.Trash.Prefix != "" {
+			config.Trash.Prefix = strings.Trim(entry.Trash.Prefix, "/")
+		}
+		if entry.Trash.Retention != nil {
+			config.Trash.Retention = *entry.Trash.Retention
+		}
+	}
+	if entry.Redirect != nil {
+		config.Redirect = defaultRedirectPolicy()
+		if entry.Redirect.Enabled != nil {
+			config.Redirect.Enabled = *entry.Redirect.Enabled
+		}
+		if entry.Redirect.MinSize != nil {
+			config.Redirect.MinSize = *entry.Redirect.MinSize
+		}
+		if entry.Redirect.Expiry != nil {
+			config.Redirect.Expiry = *entry.Redirect.Expiry
+		}
+		if config.Redirect.Enabled && config.Type != pool.ConfigTypeAzure {
+			return nil, fmt.Errorf("redirect needs an azure remote, not %s", config.Type)
+		}
+		if config.Redirect.Enabled && config.AccountKey == "" {
+			return nil, fmt.Errorf("redirect needs the account key to sign SAS URLs")
+		}
+		if config.Redirect.Expiry <= 0 {
+			return nil, fmt.Errorf("redirect expiry must be positive")
+		}
+	}
+	if entry.ACL != nil {
+		config.ACL = &auth.ACL{Read: entry.ACL.Read, Write: entry.ACL.Write}
+		if err := config.ACL.Validate(); err != nil {
+			return nil, fmt.Errorf("acl, %w", err)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+)
+
+// testAccountKey is a base64 Azure account key, only used to sign.
+const testAccountKey = "dGVzdC1hY2NvdW50LWtleS10ZXN0LWFjY291bnQta2V5"
+
+func writeRegistry(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "remotes.yaml")
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func newTestLoader(t *testing.T) *storageSiteLoader {
+	t.Helper()
+	t.Setenv("AZURE_STORAGE_URL", "")
+	for _, name := range []string{"COMPRESSION_ENABLED", "COMPRESSION_MIN_SIZE", "COMPRESSION_TYPES", "COMPRESSION_SKIP_TYPES"} {
+		// Restored after the test by Setenv
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	return NewStorageSiteLoader(t.TempDir())
+}
+
+func TestLoadFile(t *testing.T) {
+	loader := newTestLoader(t)
+	t.Setenv("TEST_ACCOUNT_KEY", testAccountKey)
+	name := writeRegistry(t, `
+default: datasets
+remotes:
+  - id: 0
+    name: local
+    type: local
+    container: remote-folder
+  - id: 1
+    name: datasets
+    aliases: [datasets-eu]
+    type: azure
+    container: test
+    prefix: dvc
+    account_name: account
+    account_key: ${TEST_ACCOUNT_KEY}
+    allow_delete: true
+    trash:
+      prefix: /deleted/
+      retention: 72h
+    redirect:
+      min_size: 4096
+    acl:
+      read: [anonymous]
+      write: [ci, group:ml]
+    compression:
+      enabled: true
+      content_types: ["*"]
+      skip_types: [image/]
+  - name: minio
+    type: s3
+    container: dvc
+    endpoint: http://minio:9000
+    path_style: true
+  - name: gcs
+    type: gs
+    container: bucket
+    emulator_host: localhost:4443
+  - name: scratch
+    type: memory
+    max_size: 1024
+`)
+	if err := loader.LoadFile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	for remote, want := range map[string]pool.ConfigType{
+		"0":           pool.ConfigTypeHttp,
+		"local":       pool.ConfigTypeHttp,
+		"1":           pool.ConfigTypeAzure,
+		"datasets-eu": pool.ConfigTypeAzure,
+		"minio":       pool.ConfigTypeS3,
+		"gcs":         pool.ConfigTypeGCS,
+		"scratch":     pool.ConfigTypeMemory,
+	} {
+		config, err := loader.LoadConfig(remote)
+		if err != nil {
+			t.Errorf("%s: %v", remote, err)
+			continue
+		}
+		if config.Type != want {
+			t.Errorf("%s: type %s, want %s", remote, config.Type, want)
+		}
+	}
+	if len(loader.Remotes()) != 5 {
+		t.Errorf("%d remotes, want 5", len(loader.Remotes()))
+	}
+
+	datasets, err := loader.LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if datasets.Name != "datasets" || datasets.Prefix != "dvc" || datasets.AccountKey != testAccountKey || !datasets.AllowDelete {
+		t.Errorf("default remote %+v", datasets)
+	}
+	if datasets.Trash != (pool.TrashPolicy{Prefix: "deleted", Retention: 72 * time.Hour}) {
+		t.Errorf("trash %+v", datasets.Trash)
+	}
+	if datasets.Redirect != (pool.RedirectPolicy{Enabled: true, MinSize: 4096, Expiry: 15 * time.Minute}) {
+		t.Errorf("redirect %+v", datasets.Redirect)
+	}
+	if datasets.ACL == nil || !reflect.DeepEqual(datasets.ACL.Read, []string{"anonymous"}) || !reflect.DeepEqual(datasets.ACL.Write, []string{"ci", "group:ml"}) {
+		t.Errorf("acl %+v", datasets.ACL)
+	}
+	if compression := datasets.Compression; !compression.Enabled || compression.MinSize != 1024 ||
+		!reflect.DeepEqual(compression.ContentTypes, []string{"*"}) || !reflect.DeepEqual(compression.SkipTypes, []string{"image/"}) {
+		t.Errorf("compression %+v", compression)
+	}
+
+	minio, _ := loader.LoadConfig("minio")
+	if minio.RemoteId != -1 || minio.S3.Endpoint != "http://minio:9000" || !minio.S3.PathStyle || minio.ACL != nil || minio.AllowDelete {
+		t.Errorf("minio %+v", minio)
+	}
+	if minio.Compression.Enabled || minio.Trash.Prefix != ".trash" || minio.Redirect.Enabled {
+		t.Errorf("minio defaults %+v", minio)
+	}
+	if gcs, _ := loader.LoadConfig("gcs"); gcs.GCS.EmulatorHost != "localhost:4443" {
+		t.Errorf("gcs %+v", gcs.GCS)
+	}
+	if scratch, _ := loader.LoadConfig("scratch"); scratch.Memory.MaxSize != 1024 {
+		t.Errorf("scratch %+v", scratch.Memory)
+	}
+	// The remotes of the file replace the built-in ones
+	if _, err := loader.LoadConfig("azure"); !errors.Is(err, ErrRemoteNotFound) {
+		t.Errorf("built-in remote still there, %v", err)
+	}
+}
+
+func TestLoadFileInvalid(t *testing.T) {
+	t.Setenv("TEST_ACCOUNT_KEY", testAccountKey)
+	tests := []struct {
+		name    string
+		remotes string
+		err     string
+	}{
+		{"unknown type", `[{name: a, type: ftp, container: c}]`, "unknown remote type"},
+		{"no name nor id", `[{type: local, container: c}]`, "an id or a name is required"},
+		{"no container", `[{name: a, type: s3}]`, "container is required"},
+		{"negative id", `[{id: -1, name: a, type: local, container: c}]`, "must be positive"},
+		{"declared twice", `[{name: a, type: local, container: c}, {name: b, aliases: [a], type: local, container: c}]`, "declared twice"},
+		{"empty principal", `[{name: a, type: local, container: c, acl: {read: [""]}}]`, "invalid principal"},
+		{"group without name", `[{name: a, type: local, container: c, acl: {write: ["group:"]}}]`, "invalid principal"},
+		{"redirect on local", `[{name: a, type: local, container: c, redirect: {}}]`, "redirect needs an azure remote"},
+		{"redirect without key", `[{name: a, type: azure, container: c, account_name: n, redirect: {}}]`, "redirect needs the account key"},
+		{"redirect expiry", `[{name: a, type: azure, container: c, account_name: n, account_key: "${TEST_ACCOUNT_KEY}", redirect: {expiry: 0s}}]`, "expiry must be positive"},
+		{"unknown default", `[{name: a, type: local, container: c}]` + "\ndefault: b", "remote not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loader := newTestLoader(t)
+			if err := loader.LoadFile(writeRegistry(t, "default: keep\nremotes: [{name: keep, type: local, container: c}]")); err != nil {
+				t.Fatal(err)
+			}
+			err := loader.LoadFile(writeRegistry(t, "remotes: "+test.remotes))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("LoadFile returned %v, want %q", err, test.err)
+			}
+			// A file that does not validate leaves the remotes untouched
+			if config, err := loader.LoadConfig(""); err != nil || config.Name != "keep" {
+				t.Errorf("default remote %v, %v after a failed load", config, err)
+			}
+			if len(loader.Remotes()) != 1 {
+				t.Errorf("%d remotes after a failed load, want 1", len(loader.Remotes()))
+			}
+		})
+	}
+
+	// Redirect may be disabled on any remote
+	loader := newTestLoader(t)
+	if err := loader.LoadFile(writeRegistry(t, `remotes: [{name: a, type: local, container: c, redirect: {enabled: false}}]`)); err != nil {
+		t.Errorf("disabled redirect on a local remote: %v", err)
+	}
+}
@@ -1,35 +1,236 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/atekoa/dvc-http-remote/pkg/dvc"
 	"github.com/atekoa/dvc-http-remote/pkg/pool"
-	"github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
 )
 
+// ErrRemoteNotFound is returned by LoadConfig for identifiers that are not
+// declared in the registry.
+var ErrRemoteNotFound = errors.New("remote not found")
+
+// defaultContentTypes are the compressible media types. DVC objects are
+// mostly application/octet-stream binaries, which are sent as is.
+var defaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/x-ndjson",
+	"application/xml",
+	"application/yaml",
+	"application/x-yaml",
+}
+
+// defaultSkipTypes are the media types already compressed, which would only
+// cost CPU for nothing if "*" is made compressible.
+var defaultSkipTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+}
+
 type storageSiteLoader struct {
-	cache     *cache.Cache
 	localPath string
+
+	mu            sync.RWMutex
+	remotes       map[string]*pool.ConnectionConfig
+	defaultRemote string
 }
 
+// NewStorageSiteLoader creates a loader with the default remotes: remote 0 is
+// the local folder path and, when AZURE_STORAGE_URL is set, remote 1 is that
+// Azure container.
 func NewStorageSiteLoader(path string) *storageSiteLoader {
-	return &storageSiteLoader{
-		cache:     cache.New(30*time.Minute, 60*time.Minute),
+	s := &storageSiteLoader{
 		localPath: path,
+		remotes:   map[string]*pool.ConnectionConfig{},
+	}
+
+	s.register(&pool.ConnectionConfig{
+		Name:          "local",
+		Type:          pool.ConfigTypeHttp,
+		ContainerName: path,
+		RemoteId:      0,
+		Compression:   compressionPolicyFromEnv(),
+		Trash:         defaultTrashPolicy(),
+	})
+
+	azureUrl := os.Getenv("AZURE_STORAGE_URL")              // "azure://test/"
+	azureConnection := os.Getenv("AZURE_CONNECTION_STRING") // "DefaultEndpointsProtocol=https;AccountName=..."
+	if azureUrl != "" {
+		config, err := dvc.LoadAzureConfig(azureUrl, azureConnection)
+		if err != nil {
+			log.
+				WithField("AZURE_STORAGE_URL", azureUrl).
+				WithError(err).
+				Fatal("Cannot load the Azure remote")
+		}
+		config.Name = "azure"
+		config.RemoteId = 1
+		config.Compression = compressionPolicyFromEnv()
+		config.Trash = defaultTrashPolicy()
+		s.register(config)
+	}
+	return s
+}
+
+// LoadConfig returns the configuration of the remote identified by its name,
+// one of its aliases or its numeric id. An empty identifier selects the
+// default remote.
+func (s *storageSiteLoader) LoadConfig(remote string) (*pool.ConnectionConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if remote == "" {
+		if s.defaultRemote == "" {
+			return nil, fmt.Errorf("%w: no remote given and no default remote", ErrRemoteNotFound)
+		}
+		remote = s.defaultRemote
+	}
+	config, ok := s.remotes[remote]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrRemoteNotFound, remote)
+	}
+	return config, nil
+}
+
+// Remotes returns every registered remote once.
+func (s *storageSiteLoader) Remotes() []*pool.ConnectionConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := map[*pool.ConnectionConfig]bool{}
+	remotes := []*pool.ConnectionConfig{}
+	for _, config := range s.remotes {
+		if !seen[config] {
+			seen[config] = true
+			remotes = append(remotes, config)
+		}
 	}
+	return remotes
 }
 
-func (s *storageSiteLoader) LoadConfig(local bool) (*pool.ConnectionConfig, error) {
-	if local {
-		return &pool.ConnectionConfig{
-			Type:          pool.ConfigTypeHttp,
-			ContainerName: s.localPath,
-		}, nil
-	} else {
-		azureUrl := os.Getenv("AZURE_STORAGE_URL")              // "azure://test/"
-		azureConnection := os.Getenv("AZURE_CONNECTION_STRING") // "DefaultEndpointsProtocol=https;AccountName=..."
-		return dvc.LoadAzureConfig(azureUrl, azureConnection)
+// SetDefault selects the remote used by requests that do not name one.
+func (s *storageSiteLoader) SetDefault(remote string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.remotes[remote]; !ok {
+		return fmt.Errorf("%w: %q", ErrRemoteNotFound, remote)
+	}
+	s.defaultRemote = remote
+	return nil
+}
+
+// LoadFile replaces the registered remotes with the ones declared in the
+// configuration file name. The remotes are left untouched when the file is
+// not valid.
+func (s *storageSiteLoader) LoadFile(name string) error {
+	configs, defaultRemote, err := loadRegistryFile(name)
+	if err != nil {
+		return err
+	}
+
+	remotes := map[string]*pool.ConnectionConfig{}
+	for _, config := range configs {
+		if err := addRemote(remotes, config); err != nil {
+			return fmt.Errorf("in %s, %w", name, err)
+		}
+	}
+	if _, ok := remotes[defaultRemote]; defaultRemote != "" && !ok {
+		return fmt.Errorf("in %s, default %w: %q", name, ErrRemoteNotFound, defaultRemote)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remotes = remotes
+	s.defaultRemote = defaultRemote
+	return nil
+}
+
+// register makes config reachable by its name, its aliases and its id.
+func (s *storageSiteLoader) register(config *pool.ConnectionConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return addRemote(s.remotes, config)
+}
+
+// addRemote adds config to remotes under its name, its aliases and its id. A
+// negative id leaves the remote reachable by name only.
+func addRemote(remotes map[string]*pool.ConnectionConfig, config *pool.ConnectionConfig) error {
+	keys := []string{}
+	if config.RemoteId >= 0 {
+		keys = append(keys, strconv.Itoa(config.RemoteId))
+	}
+	if config.Name != "" {
+		keys = append(keys, config.Name)
+	}
+	keys = append(keys, config.Aliases...)
+	for _, key := range keys {
+		if other, ok := remotes[key]; ok && other != config {
+			return fmt.Errorf("remote %q is declared twice", key)
+		}
+	}
+	for _, key := range keys {
+		remotes[key] = config
+	}
+	return nil
+}
+
+// defaultTrashPolicy keeps deleted objects under .trash for a week.
+func defaultTrashPolicy() pool.TrashPolicy {
+	return pool.TrashPolicy{
+		Prefix:    ".trash",
+		Retention: 7 * 24 * time.Hour,
+	}
+}
+
+// defaultRedirectPolicy redirects the downloads of 1MiB and more to SAS URLs
+// valid for 15 minutes.
+func defaultRedirectPolicy() pool.RedirectPolicy {
+	return pool.RedirectPolicy{
+		Enabled: true,
+		MinSize: 1 << 20,
+		Expiry:  15 * time.Minute,
+	}
+}
+
+// compressionPolicyFromEnv reads COMPRESSION_ENABLED, COMPRESSION_MIN_SIZE,
+// COMPRESSION_TYPES and COMPRESSION_SKIP_TYPES. Compression is off by default,
+// once enabled it applies to the .dir manifests and the text objects of 1KiB
+// and more.
+func compressionPolicyFromEnv() pool.CompressionPolicy {
+	policy := pool.CompressionPolicy{
+		MinSize:      1024,
+		ContentTypes: defaultContentTypes,
+		SkipTypes:    defaultSkipTypes,
+	}
+	if value, ok := os.LookupEnv("COMPRESSION_ENABLED"); ok {
+		policy.Enabled, _ = strconv.ParseBool(value)
+	}
+	if value, err := strconv.ParseInt(os.Getenv("COMPRESSION_MIN_SIZE"), 10, 64); err == nil {
+		policy.MinSize = value
+	}
+	if value, ok := os.LookupEnv("COMPRESSION_TYPES"); ok {
+		policy.ContentTypes = strings.Split(value, ",")
+	}
+	if value, ok := os.LookupEnv("COMPRESSION_SKIP_TYPES"); ok {
+		policy.SkipTypes = strings.Split(value, ",")
 	}
+	return policy
 }
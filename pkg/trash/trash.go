@@ -0,0 +1,160 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	log "github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// ErrNotInTrash is returned by Restore when the key was never deleted or has
+// been purged since.
+var ErrNotInTrash = errors.New("object is not in the trash")
+
+// Deleted objects are kept under <prefix>/<key>/<unix time of the deletion>,
+// so the retention does not depend on how each backend dates copies. The time
+// is in nanoseconds, so that deleting a key twice within a second keeps both
+// copies.
+func trashKey(policy pool.TrashPolicy, key string, deletedAt time.Time) string {
+	return path.Join(policy.Prefix, key, strconv.FormatInt(deletedAt.UnixNano(), 10))
+}
+
+// deletedAt returns the time of the deletion of a copy in the trash. Copies
+// trashed by older versions are dated in seconds.
+func deletedAt(trashKey string) (time.Time, bool) {
+	value, err := strconv.ParseInt(path.Base(trashKey), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// Nanoseconds since 1970 went past 1e12 within the first 20 minutes,
+	// seconds will not before the year 33658.
+	if value < 1e12 {
+		return time.Unix(value, 0), true
+	}
+	return time.Unix(0, value), true
+}
+
+// Move deletes key from the bucket, keeping a copy in the trash.
+func Move(ctx context.Context, bucket *blob.Bucket, policy pool.TrashPolicy, key string) error {
+	if err := bucket.Copy(ctx, trashKey(policy, key, time.Now()), key, nil); err != nil {
+		return err
+	}
+	return bucket.Delete(ctx, key)
+}
+
+// Restore puts back the last deleted copy of key.
+func Restore(ctx context.Context, bucket *blob.Bucket, policy pool.TrashPolicy, key string) error {
+	latest := ""
+	var latestTime time.Time
+	iter := bucket.List(&blob.ListOptions{Prefix: path.Join(policy.Prefix, key) + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		deleted, ok := deletedAt(obj.Key)
+		if !ok {
+			continue
+		}
+		if latest == "" || deleted.After(latestTime) {
+			latest, latestTime = obj.Key, deleted
+		}
+	}
+	if latest == "" {
+		return fmt.Errorf("%w: %s", ErrNotInTrash, key)
+	}
+
+	if err := bucket.Copy(ctx, key, latest, nil); err != nil {
+		return err
+	}
+	return bucket.Delete(ctx, latest)
+}
+
+// Purge removes the copies deleted for longer than the retention of the
+// policy and returns how many were removed.
+func Purge(ctx context.Context, bucket *blob.Bucket, policy pool.TrashPolicy, now time.Time) (int, error) {
+	if policy.Retention <= 0 {
+		return 0, nil
+	}
+
+	purged := 0
+	iter := bucket.List(&blob.ListOptions{Prefix: strings.TrimSuffix(policy.Prefix, "/") + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return purged, nil
+		}
+		if err != nil {
+			return purged, err
+		}
+		deleted, ok := deletedAt(obj.Key)
+		if !ok || now.Sub(deleted) < policy.Retention {
+			continue
+		}
+		if err := bucket.Delete(ctx, obj.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return purged, err
+		}
+		purged++
+	}
+}
+
+// Run purges the trash of every remote allowing deletes each interval, until
+// ctx is done.
+func Run(ctx context.Context, remotes func() []*pool.ConnectionConfig, connections *pool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, config := range remotes() {
+				if !config.AllowDelete {
+					continue
+				}
+				purgeRemote(ctx, connections, config, now)
+			}
+		}
+	}
+}
+
+func purgeRemote(ctx context.Context, connections *pool.Pool, config *pool.ConnectionConfig, now time.Time) {
+	conn, err := connections.Get(ctx, config)
+	if err != nil {
+		log.
+			WithField("remote", config.Name).
+			WithField("remoteID", config.RemoteId).
+			WithError(err).
+			Error("Cannot connect to purge the trash")
+		return
+	}
+	defer conn.Close()
+
+	purged, err := Purge(ctx, conn.Bucket, config.Trash, now)
+	if err != nil {
+		log.
+			WithField("remote", config.Name).
+			WithField("remoteID", config.RemoteId).
+			WithError(err).
+			Error("Cannot purge the trash")
+	}
+	if purged > 0 {
+		log.
+			WithField("remote", config.Name).
+			WithField("remoteID", config.RemoteId).
+			WithField("purged", purged).
+			Info("Trash purged")
+	}
+}
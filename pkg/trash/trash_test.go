@@ -0,0 +1,65 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/atekoa/dvc-http-remote/pkg/pool"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestMoveRestorePurge(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	policy := pool.TrashPolicy{Prefix: ".trash", Retention: time.Hour}
+
+	// Two deletions of the same key within a second keep both copies
+	for _, content := range []string{"first", "second"} {
+		if err := bucket.WriteAll(ctx, "ab/cd", []byte(content), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := Move(ctx, bucket, policy, "ab/cd"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A copy trashed by an older version, dated in seconds
+	legacy := ".trash/ab/cd/1500000000"
+	if err := bucket.WriteAll(ctx, legacy, []byte("legacy"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(ctx, bucket, policy, "ab/cd"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := bucket.ReadAll(ctx, "ab/cd"); err != nil || string(content) != "second" {
+		t.Errorf("restored %q, %v, want the last deleted copy", content, err)
+	}
+	if err := Restore(ctx, bucket, policy, "ab/cd"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := bucket.ReadAll(ctx, "ab/cd"); err != nil || string(content) != "first" {
+		t.Errorf("restored %q, %v, want the first deleted copy", content, err)
+	}
+
+	// The legacy copy is past the retention, the new one is not
+	if err := Move(ctx, bucket, policy, "ab/cd"); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := Purge(ctx, bucket, policy, time.Now())
+	if err != nil || purged != 1 {
+		t.Errorf("purged %d, %v, want the legacy copy", purged, err)
+	}
+	if exists, _ := bucket.Exists(ctx, legacy); exists {
+		t.Errorf("legacy copy not purged")
+	}
+	purged, err = Purge(ctx, bucket, policy, time.Now().Add(2*time.Hour))
+	if err != nil || purged != 1 {
+		t.Errorf("purged %d, %v, want the last copy", purged, err)
+	}
+	if err := Restore(ctx, bucket, policy, "ab/cd"); !errors.Is(err, ErrNotInTrash) {
+		t.Errorf("Restore of a purged key returned %v", err)
+	}
+}